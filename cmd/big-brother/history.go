@@ -0,0 +1,158 @@
+package main
+
+import (
+	"big-brother/internal/models"
+	"big-brother/internal/state"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// history lists the recorded runs matching filter, or shows the run with the
+// given id in full.
+func history(store *state.Store, id string, filter state.Filter, jsonOutput bool) error {
+	var output interface{}
+	if id != "" {
+		run, err := store.Load(id)
+		if err != nil {
+			return err
+		}
+		output = run
+		if !jsonOutput {
+			printRun(run)
+			return nil
+		}
+	} else {
+		runs, err := store.List(filter)
+		if err != nil {
+			return err
+		}
+		output = runs
+		if !jsonOutput {
+			printRunHistoryTable(runs)
+			return nil
+		}
+	}
+
+	jsonBytes, err := json.MarshalIndent(output, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling JSON: %w", err)
+	}
+	fmt.Println(string(jsonBytes))
+	return nil
+}
+
+// parseTime reads a -since or -until value, either a duration before now
+// ("24h") or a date ("2006-01-02") or time ("2006-01-02 15:04", RFC 3339).
+func parseTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04", time.DateOnly} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time: %s (use a duration like 24h or a date like 2006-01-02)", value)
+}
+
+// runTarget describes what a run was for, such as "restart -s api".
+func runTarget(run *models.Run) string {
+	target := run.Command
+	if run.Service != "" {
+		target += " -s " + run.Service
+	}
+	if run.Process != "" {
+		target += " -p " + run.Process
+	}
+	return target
+}
+
+func printRunHistoryTable(runs []*models.Run) {
+	const (
+		idWidth       = 30
+		startedWidth  = 19
+		commandWidth  = 35
+		userWidth     = 12
+		statusWidth   = 10
+		durationWidth = 10
+	)
+
+	fmt.Printf("%-*s %-*s %-*s %-*s %-*s %-*s\n",
+		idWidth, "Run",
+		startedWidth, "Started",
+		commandWidth, "Command",
+		userWidth, "User",
+		statusWidth, "Result",
+		durationWidth, "Duration")
+	fmt.Println(strings.Repeat("-", idWidth+startedWidth+commandWidth+userWidth+statusWidth+durationWidth+5))
+
+	for _, run := range runs {
+		fmt.Printf("%-*s %-*s %-*s %-*s %-*s %-*s\n",
+			idWidth, run.ID,
+			startedWidth, run.Started.Local().Format(time.DateTime),
+			commandWidth, truncateString(runTarget(run), commandWidth),
+			userWidth, truncateString(run.User, userWidth),
+			statusWidth, run.Status,
+			durationWidth, run.Finished.Sub(run.Started).Round(time.Millisecond))
+	}
+}
+
+func printRun(run *models.Run) {
+	fmt.Printf("Run:      %s\n", run.ID)
+	fmt.Printf("Command:  %s\n", strings.Join(run.Args, " "))
+	fmt.Printf("Config:   %s\n", run.Config)
+	fmt.Printf("User:     %s\n", run.User)
+	fmt.Printf("Started:  %s\n", run.Started.Local().Format(time.DateTime))
+	fmt.Printf("Duration: %s\n", run.Finished.Sub(run.Started).Round(time.Millisecond))
+	fmt.Printf("Result:   %s\n", run.Status)
+	if run.Error != "" {
+		fmt.Printf("Error:    %s\n", run.Error)
+	}
+
+	fmt.Println("\nResults:")
+	for _, result := range run.Results {
+		fmt.Printf("  %-35s %-15s %-10s %s\n", truncateString(result.Name, 35), result.Status, result.Duration.Round(time.Millisecond), result.Error)
+		for _, note := range result.Notes {
+			fmt.Printf("    - %s\n", note)
+		}
+	}
+
+	fmt.Println("\nCommands:")
+	for _, command := range run.Commands {
+		target := command.Host
+		if command.Process != "" {
+			target = command.Process + "@" + target
+		}
+		if command.Service != "" {
+			target = command.Service + "/" + target
+		}
+		action := command.Action
+		if action == "" {
+			action = "command"
+		}
+		if command.Attempt > 1 {
+			action += fmt.Sprintf(", attempt %d", command.Attempt)
+		}
+		fmt.Printf("  %s %s [%s] exit %d in %s: %s\n",
+			command.Started.Local().Format(time.TimeOnly),
+			target,
+			action,
+			command.ExitCode,
+			command.Duration.Round(time.Millisecond),
+			command.Command)
+		if command.Error != "" {
+			fmt.Printf("    error: %s\n", command.Error)
+		}
+		if command.OutputFile != "" {
+			fmt.Printf("    output: %s\n", command.OutputFile)
+		}
+	}
+}
@@ -2,78 +2,366 @@ package main
 
 import (
 	"big-brother/internal/app"
+	"big-brother/internal/executor"
+	"big-brother/internal/lock"
 	"big-brother/internal/logger"
 	"big-brother/internal/models"
+	"big-brother/internal/scheduler"
+	"big-brother/internal/state"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"sort"
 	"strings"
+	"syscall"
+	"time"
 )
 
 func main() {
-	service := flag.String("s", "", "Service to start/stop/check")
-	process := flag.String("p", "", "Process to start/stop/check (only with -s)")
-	verbose := flag.Bool("v", false, "Enable verbose logging")
-	jsonOutput := flag.Bool("j", false, "Enable JSON output for check")
+	os.Exit(run())
+}
+
+// run executes the command and returns the exit code, so that deferred
+// cleanup happens before the process exits.
+func run() int {
+	service := flag.String("s", "", "Service to start/stop/restart/check")
+	process := flag.String("p", "", "Process to start/stop/restart/check (only with -s)")
+	verbose := flag.Bool("v", false, "Enable verbose logging (same as -log-level debug)")
+	logLevel := flag.String("log-level", "", "Lowest level logged: debug, info, warn or error (default warn, debug with -v)")
+	logFormat := flag.String("log-format", "text", "Log format: text or json")
+	logFile := flag.String("log-file", "", "Write the log to this file instead of stderr")
+	logMaxSize := flag.Int("log-max-size", 10, "Megabytes after which the log file is rotated, 0 to never rotate")
+	logMaxFiles := flag.Int("log-max-files", 5, "Number of rotated log files kept")
+	jsonOutput := flag.Bool("j", false, "Enable JSON output for check and dry-run")
+	quiet := flag.Bool("q", false, "Do not print the output of the commands run for processes")
+	dryRun := flag.Bool("dry-run", false, "Print the plan for start/stop/restart without executing anything")
 	configFilePath := flag.String("c", "config/config.yaml", "Config file path")
 	ignoreCheck := flag.Bool("ic", false, "Ignore dependency checks")
+	force := flag.Bool("force", false, "Run start/stop commands even for processes already running/stopped")
 	threadCount := flag.Int("t", 1, "Number of threads for parallel processing")
+	failurePolicy := flag.String("on-failure", "", "What to do when a service fails: fail-fast, continue or rollback (default from config, else fail-fast)")
+	batchSize := flag.String("batch", "1", "Processes restarted at once by rolling-restart, as a count or a percentage")
+	interval := flag.Int("interval", 0, "Seconds between checks for supervise (default from config, else 10)")
+	stateDir := flag.String("state-dir", "", "Directory of the run history (default from config, else ~/.big-brother)")
+	since := flag.String("since", "", "Only show runs started since, as a duration (24h) or date (2006-01-02), for history")
+	until := flag.String("until", "", "Only show runs started until, as a duration or date, for history")
+	limit := flag.Int("n", 20, "Number of runs shown by history, 0 for all")
+	lockWait := flag.Int("lock-wait", 0, "Seconds to wait for the run lock held by someone else")
+	forceUnlock := flag.Bool("force-unlock", false, "Remove the run lock, whoever holds it, before running the command")
+	rollbackOnFailure := flag.Bool("rollback-on-failure", false, "Stop the services a failed start run started (same as -on-failure rollback)")
 
 	flag.Parse()
 
 	command := flag.Arg(0)
 	if command == "" {
-		fmt.Println("Usage: big-brother [start|stop|check] [options]")
+		fmt.Println("Usage: big-brother [start|stop|restart|rolling-restart|check|supervise|history] [options]")
 		flag.PrintDefaults()
-		os.Exit(1)
+		return 1
 	}
 
 	// Initialize logger
-	logger := logger.NewLogger(*verbose)
+	logger, err := newLogger(*logLevel, *verbose, *logFormat, *logFile, *logMaxSize, *logMaxFiles)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer logger.Close()
+
+	if *rollbackOnFailure {
+		if *failurePolicy != "" && models.FailurePolicy(*failurePolicy) != models.RollbackOnFailure {
+			logger.Errorf("-rollback-on-failure conflicts with -on-failure %s", *failurePolicy)
+			return 1
+		}
+		*failurePolicy = string(models.RollbackOnFailure)
+	}
+
+	// Cancel running commands on Ctrl-C or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	// Create app instance
-	app := app.NewApp(*configFilePath, *threadCount, *ignoreCheck, logger)
+	app, err := app.NewApp(*configFilePath, *threadCount, *ignoreCheck, *force, models.FailurePolicy(*failurePolicy), logger)
+	if err != nil {
+		logger.Errorf("%v", err)
+		return 1
+	}
+	defer app.Close()
+	if !*quiet {
+		app.Executor.SetStream(executor.NewStream(os.Stdout, os.Stderr))
+	}
 
-	switch command {
-	case "start":
-		if *service == "" {
-			app.StartAll()
-		} else if *process == "" {
-			app.StartService(*service)
+	if *dryRun {
+		plan, err := app.Plan(command, *service, *process)
+		if err != nil {
+			logger.Errorf("%v", err)
+			return 1
+		}
+		if *jsonOutput {
+			jsonBytes, err := json.MarshalIndent(plan, "", "  ")
+			if err != nil {
+				logger.Errorf("Error marshaling JSON: %v", err)
+				return 1
+			}
+			fmt.Println(string(jsonBytes))
 		} else {
-			app.StartProcess(*service, *process)
+			printPlan(plan)
+		}
+		return 0
+	}
+
+	// Record every run that may change something
+	store, err := app.OpenStateStore(*stateDir)
+	if err != nil && command != "history" {
+		logger.Warnf("Not recording this run: %v", err)
+		err = nil
+	}
+
+	if *forceUnlock {
+		holder, unlockErr := app.ForceUnlock(ctx)
+		switch {
+		case unlockErr != nil:
+			logger.Errorf("%v", unlockErr)
+			return 1
+		case holder != nil:
+			fmt.Printf("Removed the lock held by %s\n", holder)
+		default:
+			fmt.Println("The lock was not held.")
 		}
-	case "stop":
+	}
+
+	// Only one operator at a time may change the services
+	switch command {
+	case "start", "stop", "restart", "rolling-restart":
+		runLock, lockErr := app.Lock(ctx, time.Duration(*lockWait)*time.Second)
+		if lock.IsLocked(lockErr) {
+			logger.Errorf("%v (wait for it with -lock-wait, or remove it with -force-unlock)", lockErr)
+			return 1
+		}
+		if lockErr != nil {
+			logger.Errorf("%v", lockErr)
+			return 1
+		}
+		defer func() {
+			if err := runLock.Unlock(context.WithoutCancel(ctx)); err != nil {
+				logger.Errorf("%v", err)
+			}
+		}()
+	}
+
+	switch command {
+	case "history":
+		if err != nil {
+			break
+		}
+		now := time.Now()
+		filter := state.Filter{Service: *service, Limit: *limit}
+		if filter.Since, err = parseTime(*since, now); err != nil {
+			break
+		}
+		if filter.Until, err = parseTime(*until, now); err != nil {
+			break
+		}
+		err = history(store, flag.Arg(1), filter, *jsonOutput)
+	case "start", "stop", "restart":
+		var results []scheduler.Result
+		switch {
+		case command == "start" && *service == "":
+			results = app.StartAll(ctx)
+		case command == "start" && *process == "":
+			results, err = app.StartService(ctx, *service)
+		case command == "start":
+			results, err = app.StartProcess(ctx, *service, *process)
+		case command == "stop" && *service == "":
+			results = app.StopAll(ctx)
+		case command == "stop" && *process == "":
+			results, err = app.StopService(ctx, *service)
+		case command == "stop":
+			results, err = app.StopProcess(ctx, *service, *process)
+		case *service == "":
+			results = app.RestartAll(ctx)
+		case *process == "":
+			results, err = app.RestartService(ctx, *service)
+		default:
+			results, err = app.RestartProcess(ctx, *service, *process)
+		}
+		if err == nil {
+			return summarize(results)
+		}
+	case "rolling-restart":
 		if *service == "" {
-			app.StopAll()
-		} else if *process == "" {
-			app.StopService(*service)
-		} else {
-			app.StopProcess(*service, *process)
+			fmt.Println("rolling-restart requires -s.")
+			return 1
+		}
+		results, rollingErr := app.RollingRestart(ctx, *service, *batchSize)
+		if rollingErr != nil {
+			err = rollingErr
+			break
 		}
+		printBatchSummaryTable(results)
+		for _, result := range results {
+			if result.Status != scheduler.StatusSucceeded {
+				return 1
+			}
+		}
+		return 0
+	case "supervise":
+		// Runs until Ctrl-C or SIGTERM
+		app.NewSupervisor().Run(ctx, time.Duration(*interval)*time.Second, func(results []scheduler.Result) {
+			fmt.Printf("\n%s\n", time.Now().Format(time.DateTime))
+			printRunSummaryTable(results)
+		})
+		return 0
 	case "check":
 		var result []models.CheckResult
 		if *service == "" {
-			result = app.CheckAll()
+			result = app.CheckAll(ctx)
 		} else if *process == "" {
-			result = app.CheckService(*service)
+			result, err = app.CheckService(ctx, *service)
 		} else {
-			result = app.CheckProcess(*service, *process)
+			result, err = app.CheckProcess(ctx, *service, *process)
+		}
+		if err != nil {
+			break
 		}
 		if *jsonOutput {
 			jsonBytes, err := json.MarshalIndent(result, "", "  ")
 			if err != nil {
-				logger.Fatalf("Error marshaling JSON: %v", err)
+				logger.Errorf("Error marshaling JSON: %v", err)
+				return 1
 			}
 			fmt.Println(string(jsonBytes))
 		} else {
 			printCheckResultTable(result)
 		}
 	default:
-		fmt.Println("Invalid command. Use start, stop, or check.")
-		os.Exit(1)
+		fmt.Println("Invalid command. Use start, stop, restart, rolling-restart, check, supervise, or history.")
+		return 1
+	}
+
+	if err != nil {
+		logger.Errorf("%v", err)
+		return 1
+	}
+	return 0
+}
+
+// newLogger returns the logger configured by the -log-* flags. Without
+// -log-level it logs warnings and errors, and everything with -v.
+func newLogger(level string, verbose bool, format, file string, maxSize, maxFiles int) (*logger.Logger, error) {
+	opts := logger.Options{
+		Level:    logger.LevelWarn,
+		Format:   logger.Format(format),
+		File:     file,
+		MaxSize:  maxSize,
+		MaxFiles: maxFiles,
+	}
+	if verbose {
+		opts.Level = logger.LevelDebug
+	}
+	if level != "" {
+		var err error
+		if opts.Level, err = logger.ParseLevel(level); err != nil {
+			return nil, err
+		}
+	}
+	return logger.New(opts)
+}
+
+// summarize prints the outcome of every service in a start or stop run and
+// returns the exit code for it.
+func summarize(results []scheduler.Result) int {
+	printRunSummaryTable(results)
+	if len(scheduler.Failed(results)) > 0 {
+		return 1
+	}
+	return 0
+}
+
+func printRunSummaryTable(results []scheduler.Result) {
+	const (
+		serviceNameWidth = 35
+		statusWidth      = 15
+		durationWidth    = 10
+	)
+
+	fmt.Printf("%-*s %-*s %-*s %s\n",
+		serviceNameWidth, "Service",
+		statusWidth, "Result",
+		durationWidth, "Duration",
+		"Error")
+	fmt.Println(strings.Repeat("-", serviceNameWidth+statusWidth+durationWidth+20))
+
+	for _, result := range results {
+		errText := ""
+		if result.Err != nil {
+			errText = result.Err.Error()
+		}
+		fmt.Printf("%-*s %-*s %-*s %s\n",
+			serviceNameWidth, truncateString(result.Service.Name, serviceNameWidth),
+			statusWidth, result.Status,
+			durationWidth, result.Duration.Round(time.Millisecond),
+			errText)
+		for _, note := range result.Notes {
+			fmt.Printf("  - %s\n", note)
+		}
+	}
+}
+
+func printBatchSummaryTable(results []app.BatchResult) {
+	const (
+		batchWidth     = 6
+		processesWidth = 40
+		statusWidth    = 10
+		durationWidth  = 10
+	)
+
+	fmt.Printf("%-*s %-*s %-*s %-*s %s\n",
+		batchWidth, "Batch",
+		processesWidth, "Processes",
+		statusWidth, "Result",
+		durationWidth, "Duration",
+		"Error")
+	fmt.Println(strings.Repeat("-", batchWidth+processesWidth+statusWidth+durationWidth+20))
+
+	for i, result := range results {
+		errText := ""
+		if result.Err != nil {
+			errText = result.Err.Error()
+		}
+		fmt.Printf("%-*d %-*s %-*s %-*s %s\n",
+			batchWidth, i+1,
+			processesWidth, truncateString(strings.Join(result.Processes, ", "), processesWidth),
+			statusWidth, result.Status,
+			durationWidth, result.Duration.Round(time.Millisecond),
+			errText)
+	}
+}
+
+func printPlan(plan *models.Plan) {
+	fmt.Printf("Plan for %s (dry run, nothing is executed):\n", plan.Command)
+	if plan.Concurrency == 1 {
+		fmt.Println("Services are handled one at a time (raise with -t).")
+	} else {
+		fmt.Printf("Up to %d services are handled at a time.\n", plan.Concurrency)
+	}
+	for _, phase := range plan.Phases {
+		fmt.Printf("\n%s:\n", phase.Action)
+		for i, level := range phase.Levels {
+			fmt.Printf("  Dependency level %d:\n", i+1)
+			for _, service := range level {
+				fmt.Printf("    %s\n", service.Name)
+				for _, step := range service.Steps {
+					if step.Hook != "" {
+						fmt.Printf("      %s hook of %s@%s [%s]: %s\n", step.Hook, step.Process, step.Host, step.Runner, step.Command)
+						continue
+					}
+					fmt.Printf("      %s@%s [%s]: %s\n", step.Process, step.Host, step.Runner, step.Command)
+				}
+			}
+		}
 	}
 }
 
@@ -86,16 +374,7 @@ func printCheckResultTable(results []models.CheckResult) {
 		} else if results[i].ProcessName != results[j].ProcessName {
 			return results[i].ProcessName < results[j].ProcessName
 		} else {
-			// Convert boolean status to string for comparison
-			statusI := "Not Running"
-			if results[i].IsRunning {
-				statusI = "Running"
-			}
-			statusJ := "Not Running"
-			if results[j].IsRunning {
-				statusJ = "Running"
-			}
-			return statusI < statusJ
+			return statusText(results[i]) < statusText(results[j])
 		}
 	})
 
@@ -117,10 +396,7 @@ func printCheckResultTable(results []models.CheckResult) {
 
 	// Print each result row with truncation
 	for _, result := range results {
-		status := "Not Running"
-		if result.IsRunning {
-			status = "Running"
-		}
+		status := statusText(result)
 
 		// Truncate values if they exceed column width
 		serviceName := truncateString(result.ServiceName, serviceNameWidth)
@@ -135,6 +411,20 @@ func printCheckResultTable(results []models.CheckResult) {
 	}
 }
 
+func statusText(result models.CheckResult) string {
+	switch result.State {
+	case models.StateRunning:
+		return "Running"
+	case models.StateStopped:
+		return "Not Running"
+	case models.StateDegraded:
+		return "Degraded"
+	case models.StateTimedOut:
+		return "Timed Out"
+	}
+	return "Unknown"
+}
+
 func truncateString(str string, maxWidth int) string {
 	if len(str) > maxWidth {
 		return str[:maxWidth-3] + "..."
@@ -2,4 +2,9 @@ module big-brother
 
 go 1.22
 
-require gopkg.in/yaml.v2 v2.4.0 // indirect
+require gopkg.in/yaml.v2 v2.4.0
+
+require (
+	golang.org/x/crypto v0.31.0
+	golang.org/x/sys v0.28.0
+)
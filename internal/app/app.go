@@ -1,216 +1,222 @@
 package app
 
 import (
+	"big-brother/internal/audit"
 	"big-brother/internal/config"
 	"big-brother/internal/executor"
 	"big-brother/internal/logger"
 	"big-brother/internal/models"
+	"big-brother/internal/scheduler"
+	"big-brother/internal/state"
 	"big-brother/internal/utils"
+	"context"
 	"fmt"
-	"sync"
-	"time"
+	"os"
+	"strings"
 )
 
 type App struct {
-	config      *models.Config
-	Executor    *executor.Executor
-	logger      *logger.Logger
-	threadCount int
-	ignoreCheck bool
+	config        *models.Config
+	configPath    string
+	store         *state.Store
+	audit         *audit.Log
+	Executor      *executor.Executor
+	logger        *logger.Logger
+	threadCount   int
+	ignoreCheck   bool
+	force         bool
+	failurePolicy models.FailurePolicy
 }
 
-func NewApp(configFilePath string, threadCount int, ignoreCheck bool, logger *logger.Logger) *App {
+// NewApp loads and validates the config. A non-empty failurePolicy overrides
+// the one from the config. With force, start and stop commands run even for
+// processes already in the desired state.
+func NewApp(configFilePath string, threadCount int, ignoreCheck, force bool, failurePolicy models.FailurePolicy, log *logger.Logger) (*App, error) {
+	if err := utils.ValidateFailurePolicy(failurePolicy); err != nil {
+		return nil, err
+	}
+
 	cfg, err := config.LoadConfig(configFilePath)
 	if err != nil {
-		logger.Fatalf("Error loading config: %v", err)
+		return nil, fmt.Errorf("error loading config: %w", err)
 	}
 
 	// Validate config and build dependency tree
 	if err := utils.ValidateConfigAndBuildDependencyTree(cfg); err != nil {
-		logger.Fatalf("Config validation or dependency tree building failed: %v", err)
+		return nil, fmt.Errorf("config validation or dependency tree building failed: %w", err)
 	}
-
-	if logger.Verbose {
-		logger.Infof("Constructed Config is : %s", cfg)
-		logger.Info("Dependency Tree:")
-		utils.PrintDependencyTree(cfg.DependencyTree, "", true)
+	if failurePolicy == "" {
+		failurePolicy = cfg.FailurePolicy
 	}
 
-	return &App{
-		config:      cfg,
-		Executor:    executor.NewExecutor(logger, cfg.WaitTime),
-		logger:      logger,
-		threadCount: min(threadCount, 192),
-		ignoreCheck: ignoreCheck,
+	if log.Enabled(logger.LevelDebug) {
+		log.Debugf("Constructed Config is : %s", cfg)
+		var tree strings.Builder
+		utils.PrintDependencyTree(&tree, cfg.DependencyTree, "", true)
+		log.Debugf("Dependency Tree:\n%s", tree.String())
 	}
-}
 
-func (a *App) StartAll() {
-	a.logger.Info("Starting all services...")
+	newExecutor := executor.NewExecutor(log)
+	newExecutor.ConfigureHosts(cfg.SSH, cfg.Hosts)
 
-	// Get the root nodes of the dependency tree
-	rootNodes := utils.GetRootNodes(a.config.DependencyTree)
-
-	if a.threadCount > 1 {
-		a.processTreeParallel(rootNodes, func(s *models.Service) error {
-			return a.startService(s)
-		})
-	} else {
-		a.processTreeSequential(rootNodes, func(s *models.Service) error {
-			return a.startService(s)
-		})
+	auditLog, err := audit.New(cfg.Audit, currentUser(), os.Args)
+	if err != nil {
+		return nil, fmt.Errorf("error opening audit log: %w", err)
 	}
+	newExecutor.SetAudit(auditLog)
 
-	a.logger.Info("All services started successfully.")
+	return &App{
+		config:        cfg,
+		configPath:    configFilePath,
+		audit:         auditLog,
+		Executor:      newExecutor,
+		logger:        log,
+		threadCount:   min(threadCount, 192),
+		ignoreCheck:   ignoreCheck,
+		force:         force,
+		failurePolicy: failurePolicy,
+	}, nil
 }
 
-func (a *App) StopAll() {
-	a.logger.Info("Stopping all services...")
-
-	// Get the leaf nodes of the dependency tree (reverse order for stopping)
-	leafNodes := utils.GetLeafNodes(a.config.DependencyTree)
-
-	if a.threadCount > 1 {
-		a.processTreeParallel(leafNodes, a.stopService)
-	} else {
-		a.processTreeSequential(leafNodes, a.stopService)
+// Close releases resources held by the executor, such as SSH connections,
+// and closes the audit log.
+func (a *App) Close() {
+	a.Executor.Close()
+	if a.audit != nil {
+		a.audit.Close()
 	}
+}
 
-	a.logger.Info("All services stopped successfully.")
+// StartAll starts every service once all of its dependencies have started,
+// running up to threadCount services at a time. After a failure the failure
+// policy decides whether independent services are still started, or whether
+// the services started so far are stopped again.
+func (a *App) StartAll(ctx context.Context) []scheduler.Result {
+	ctx, run := a.beginRun(ctx, "start", "", "")
+	a.log(ctx).Info("Starting all services...")
+
+	results := a.withRollback(ctx, func(ctx context.Context) []scheduler.Result {
+		return scheduler.Run(ctx, a.config.StartOrder, utils.Dependencies, a.threadCount, a.failFast(), a.startService)
+	})
+	a.logResults(ctx, results, "started")
+	a.finishRun(run, runResults(results), nil)
+	return results
 }
 
-func (a *App) StartService(serviceName string) {
-	a.logger.Infof("Starting service: %s", serviceName)
+// StopAll stops every service once everything depending on it has stopped,
+// running up to threadCount services at a time. After a failure the failure
+// policy decides whether independent services are still stopped.
+func (a *App) StopAll(ctx context.Context) []scheduler.Result {
+	ctx, run := a.beginRun(ctx, "stop", "", "")
+	a.log(ctx).Info("Stopping all services...")
+
+	results := scheduler.Run(ctx, reverse(a.config.StartOrder), utils.Dependents, a.threadCount, a.failFast(), a.stopService)
+	a.logResults(ctx, results, "stopped")
+	a.finishRun(run, runResults(results), nil)
+	return results
+}
 
-	service, err := utils.FindServiceByName(a.config, serviceName)
-	if err != nil {
-		a.logger.Fatalf("Error finding service: %v", err)
-	}
+func (a *App) failFast() bool {
+	return a.failurePolicy != models.ContinueOnFailure
+}
 
-	// Check dependencies if ignoreCheck is false
-	if !a.ignoreCheck {
-		for _, dependency := range service.Dependencies {
-			isRunning, err := a.isServiceRunning(dependency.Name)
-			if err != nil {
-				a.logger.Fatalf("Error checking dependency status: %v", err)
-			}
-			if !isRunning {
-				a.logger.Fatalf("Dependency %s is not running. Cannot start %s.", dependency.Name, service.Name)
-			}
+func (a *App) logResults(ctx context.Context, results []scheduler.Result, done string) {
+	failed := scheduler.Failed(results)
+	for _, result := range failed {
+		if result.Err == nil {
+			a.log(ctx).Errorf("Service %s %s", result.Service.Name, result.Status)
+			continue
 		}
+		a.log(ctx).Errorf("Service %s %s: %v", result.Service.Name, result.Status, result.Err)
 	}
-
-	if err := a.Executor.StartService(service); err != nil {
-		a.logger.Fatalf("Error starting service: %v", err)
+	if len(failed) == 0 {
+		a.log(ctx).Infof("All services %s successfully.", done)
 	}
 }
 
-func (a *App) startService(service *models.Service) error {
-	a.logger.Infof("Starting service: %s", service.Name)
+// StartService starts a single service once its dependencies are running.
+func (a *App) StartService(ctx context.Context, serviceName string) (results []scheduler.Result, err error) {
+	ctx, run := a.beginRun(ctx, "start", serviceName, "")
+	a.log(ctx).Infof("Starting service: %s", serviceName)
+	defer func() { a.finishRun(run, runResults(results), err) }()
 
-	for _, process := range service.Processes {
-		a.logger.Infof("Starting process: %s on host: %s", process.Name, process.HostName)
-		_, err := a.Executor.ExecuteCommand(process.StartCmd, process.HostName)
-		if err != nil {
-			return err
-		}
-
-		// Wait for the process to start
-		time.Sleep(time.Duration(a.config.WaitTime) * time.Second)
+	service, err := utils.FindServiceByName(a.config, serviceName)
+	if err != nil {
+		return nil, err
+	}
 
-		// Check if the process is running
-		isRunning, err := a.Executor.CheckProcess(&process)
-		if err != nil {
-			return err
-		}
-		if !isRunning {
-			return fmt.Errorf("process %s on host %s failed to start", process.Name, process.HostName)
-		}
+	if err := a.checkDependencies(ctx, service); err != nil {
+		return nil, err
 	}
 
-	a.logger.Infof("Service %s started successfully.", service.Name)
-	return nil
+	return a.withRollback(ctx, func(ctx context.Context) []scheduler.Result {
+		return a.runOne(ctx, service, a.startService)
+	}), nil
 }
 
-func (a *App) StopService(serviceName string) {
-	a.logger.Infof("Stopping service: %s", serviceName)
+// StopService stops a single service, leaving its dependents alone.
+func (a *App) StopService(ctx context.Context, serviceName string) (results []scheduler.Result, err error) {
+	ctx, run := a.beginRun(ctx, "stop", serviceName, "")
+	a.log(ctx).Infof("Stopping service: %s", serviceName)
+	defer func() { a.finishRun(run, runResults(results), err) }()
 
 	service, err := utils.FindServiceByName(a.config, serviceName)
 	if err != nil {
-		a.logger.Fatalf("Error finding service: %v", err)
+		return nil, err
 	}
 
-	if err := a.Executor.StopService(service); err != nil {
-		a.logger.Fatalf("Error stopping service: %v", err)
-	}
+	return a.runOne(ctx, service, a.stopService), nil
 }
 
-func (a *App) stopService(service *models.Service) error {
-	a.logger.Infof("Stopping service: %s", service.Name)
-
-	for _, process := range service.Processes {
-		a.logger.Infof("Stopping process: %s on host: %s", process.Name, process.HostName)
-		_, err := a.Executor.ExecuteCommand(process.StopCmd, process.HostName)
-		if err != nil {
-			return err
-		}
-
-		// Wait for the process to stop
-		time.Sleep(time.Duration(a.config.WaitTime) * time.Second)
-
-		// Check if the process is stopped
-		isRunning, err := a.Executor.CheckProcess(&process)
-		if err != nil {
-			return err
-		}
-		if isRunning {
-			return fmt.Errorf("process %s on host %s failed to stop", process.Name, process.HostName)
-		}
-	}
-
-	a.logger.Infof("Service %s stopped successfully.", service.Name)
-	return nil
+// runOne runs action on a single service, reporting it like a full run.
+func (a *App) runOne(ctx context.Context, service *models.Service, action scheduler.Action) []scheduler.Result {
+	results := scheduler.Run(ctx, []*models.Service{service}, utils.Dependencies, 1, true, action)
+	a.logResults(ctx, results, "done")
+	return results
 }
 
-func (a *App) CheckAll() []models.CheckResult {
-	a.logger.Info("Checking all services...")
+func (a *App) CheckAll(ctx context.Context) []models.CheckResult {
+	a.log(ctx).Info("Checking all services...")
 	var allResults []models.CheckResult
 
 	for _, service := range a.config.Services {
-		results := a.Executor.CheckService(&service)
+		if ctx.Err() != nil {
+			break
+		}
+		results := a.Executor.CheckService(ctx, &service)
 		allResults = append(allResults, results...)
 	}
 
 	return allResults
 }
 
-func (a *App) CheckService(serviceName string) []models.CheckResult {
-	a.logger.Infof("Checking service: %s", serviceName)
+func (a *App) CheckService(ctx context.Context, serviceName string) ([]models.CheckResult, error) {
+	a.log(ctx).Infof("Checking service: %s", serviceName)
 
 	service, err := utils.FindServiceByName(a.config, serviceName)
 	if err != nil {
-		a.logger.Fatalf("Error finding service: %v", err)
+		return nil, err
 	}
 
-	return a.Executor.CheckService(service)
+	return a.Executor.CheckService(ctx, service), nil
 }
 
-func (a *App) CheckProcess(serviceName, processName string) []models.CheckResult {
-	a.logger.Infof("Checking process: %s in service: %s", processName, serviceName)
+func (a *App) CheckProcess(ctx context.Context, serviceName, processName string) ([]models.CheckResult, error) {
+	a.log(ctx).Infof("Checking process: %s in service: %s", processName, serviceName)
 
 	service, err := utils.FindServiceByName(a.config, serviceName)
 	if err != nil {
-		a.logger.Fatalf("Error finding service: %v", err)
+		return nil, err
 	}
 
 	process, err := utils.FindProcessByName(service, processName)
 	if err != nil {
-		a.logger.Fatalf("Error finding process: %v", err)
+		return nil, err
 	}
 
-	isRunning, err := a.Executor.CheckProcess(process)
+	state, err := a.Executor.CheckProcess(ctx, process)
 	if err != nil {
-		a.logger.Fatalf("Error checking process: %v", err)
+		a.log(processContext(ctx, process)).Errorf("Error checking process %s on host %s: %v", process.Name, process.HostName, err)
 	}
 
 	return []models.CheckResult{
@@ -218,88 +224,89 @@ func (a *App) CheckProcess(serviceName, processName string) []models.CheckResult
 			ServiceName: serviceName,
 			ProcessName: processName,
 			HostName:    process.HostName,
-			IsRunning:   isRunning,
+			State:       state,
+			IsRunning:   executor.IsUp(state),
 		},
-	}
+	}, nil
 }
 
-func (a *App) StartProcess(serviceName, processName string) {
-	a.logger.Infof("Starting process: %s in service: %s", processName, serviceName)
+// StartProcess starts one process of a service once the service's
+// dependencies are running.
+func (a *App) StartProcess(ctx context.Context, serviceName, processName string) (results []scheduler.Result, err error) {
+	ctx, run := a.beginRun(ctx, "start", serviceName, processName)
+	a.log(ctx).Infof("Starting process: %s in service: %s", processName, serviceName)
+	defer func() { a.finishRun(run, runResults(results), err) }()
 
 	service, err := utils.FindServiceByName(a.config, serviceName)
 	if err != nil {
-		a.logger.Fatalf("Error finding service: %v", err)
+		return nil, err
 	}
 
 	process, err := utils.FindProcessByName(service, processName)
 	if err != nil {
-		a.logger.Fatalf("Error finding process: %v", err)
+		return nil, err
 	}
 
-	// Don't wait to check start when starting only individual process
-	a.logger.Infof("Starting process: %s on host: %s", process.Name, process.HostName)
-	_, err = a.Executor.ExecuteCommand(process.StartCmd, process.HostName)
-	if err != nil {
-		a.logger.Fatalf("Error starting process: %v", err)
+	if err := a.checkDependencies(ctx, service); err != nil {
+		return nil, err
 	}
+
+	return a.withRollback(ctx, func(ctx context.Context) []scheduler.Result {
+		return a.runOne(ctx, service, func(ctx context.Context, service *models.Service) error {
+			return a.startProcesses(ctx, service, []models.Process{*process})
+		})
+	}), nil
 }
 
-func (a *App) StopProcess(serviceName, processName string) {
-	a.logger.Infof("Stopping process: %s in service: %s", processName, serviceName)
+// StopProcess stops one process of a service.
+func (a *App) StopProcess(ctx context.Context, serviceName, processName string) (results []scheduler.Result, err error) {
+	ctx, run := a.beginRun(ctx, "stop", serviceName, processName)
+	a.log(ctx).Infof("Stopping process: %s in service: %s", processName, serviceName)
+	defer func() { a.finishRun(run, runResults(results), err) }()
 
 	service, err := utils.FindServiceByName(a.config, serviceName)
 	if err != nil {
-		a.logger.Fatalf("Error finding service: %v", err)
+		return nil, err
 	}
 
 	process, err := utils.FindProcessByName(service, processName)
 	if err != nil {
-		a.logger.Fatalf("Error finding process: %v", err)
+		return nil, err
 	}
 
-	//Don't wait to check stop when stopping only individual process
-	a.logger.Infof("Stopping process: %s on host: %s", process.Name, process.HostName)
-	_, err = a.Executor.ExecuteCommand(process.StopCmd, process.HostName)
-	if err != nil {
-		a.logger.Fatalf("Error stopping process: %v", err)
-	}
+	return a.runOne(ctx, service, func(ctx context.Context, service *models.Service) error {
+		return a.stopProcesses(ctx, service, []models.Process{*process})
+	}), nil
 }
 
-func (a *App) processTreeParallel(nodes []*models.Service, action func(*models.Service) error) {
-	var wg sync.WaitGroup
-	semaphore := make(chan struct{}, a.threadCount)
-
-	for _, node := range nodes {
-		wg.Add(1)
-		semaphore <- struct{}{}
-
-		go func(service *models.Service) {
-			defer wg.Done()
-			defer func() { <-semaphore }()
-
-			if err := action(service); err != nil {
-				a.logger.Fatalf("Error processing service %s: %v", service.Name, err)
-			}
-
-			a.processTreeParallel(service.Dependents, action)
-		}(node)
+// checkDependencies returns an error unless every service the given service
+// depends on is running. It does nothing when ignoreCheck is set.
+func (a *App) checkDependencies(ctx context.Context, service *models.Service) error {
+	if a.ignoreCheck {
+		return nil
 	}
 
-	wg.Wait()
-}
-
-func (a *App) processTreeSequential(nodes []*models.Service, action func(*models.Service) error) {
-	for _, node := range nodes {
-		if err := action(node); err != nil {
-			a.logger.Fatalf("Error processing service %s: %v", node.Name, err)
+	var notRunning []string
+	for _, dependency := range service.Dependencies {
+		isRunning, err := a.isServiceRunning(ctx, dependency.Name)
+		if err != nil {
+			return fmt.Errorf("error checking dependency status: %w", err)
 		}
-
-		a.processTreeSequential(node.Dependents, action)
+		if !isRunning {
+			notRunning = append(notRunning, dependency.Name)
+		}
+	}
+	if len(notRunning) > 0 {
+		return fmt.Errorf("dependencies %s are not running, cannot start %s", strings.Join(notRunning, ", "), service.Name)
 	}
+	return nil
 }
 
-func (a *App) isServiceRunning(serviceName string) (bool, error) {
-	results := a.CheckService(serviceName)
+func (a *App) isServiceRunning(ctx context.Context, serviceName string) (bool, error) {
+	results, err := a.CheckService(ctx, serviceName)
+	if err != nil {
+		return false, err
+	}
 	for _, result := range results {
 		if result.IsRunning {
 			return true, nil
@@ -307,3 +314,22 @@ func (a *App) isServiceRunning(serviceName string) (bool, error) {
 	}
 	return false, nil
 }
+
+func reverse(services []*models.Service) []*models.Service {
+	reversed := make([]*models.Service, len(services))
+	for i, service := range services {
+		reversed[len(services)-1-i] = service
+	}
+	return reversed
+}
+
+// log returns the app's logger with the run, service, process and host of
+// ctx attached.
+func (a *App) log(ctx context.Context) *logger.Logger {
+	return executor.ContextLogger(ctx, a.logger)
+}
+
+// processContext labels ctx with process and its host.
+func processContext(ctx context.Context, process *models.Process) context.Context {
+	return executor.WithLabels(ctx, executor.Labels{Process: process.Name, Host: process.HostName})
+}
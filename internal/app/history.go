@@ -0,0 +1,145 @@
+package app
+
+import (
+	"big-brother/internal/executor"
+	"big-brother/internal/models"
+	"big-brother/internal/scheduler"
+	"big-brother/internal/state"
+	"context"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Run statuses as recorded in the state store.
+const (
+	RunSucceeded = "succeeded"
+	RunFailed    = "failed"
+)
+
+// OpenStateStore opens the state store in dir, or else in the config's
+// state_dir, or else in ~/.big-brother. From then on every start, stop,
+// restart and rolling restart, and every supervise check that restarted
+// something, is recorded there.
+func (a *App) OpenStateStore(dir string) (*state.Store, error) {
+	if dir == "" {
+		dir = a.config.StateDir
+	}
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("no state directory configured: %w", err)
+		}
+		dir = filepath.Join(home, ".big-brother")
+	}
+
+	store, err := state.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	a.store = store
+	return store, nil
+}
+
+// beginRun starts the record of a run, and returns a context under which the
+// commands the executor runs are added to it and their output is saved in the
+// run's output directory. Without a state store nothing is recorded and the
+// run is nil.
+func (a *App) beginRun(ctx context.Context, command, service, process string) (context.Context, *models.Run) {
+	if a.store == nil {
+		return ctx, nil
+	}
+
+	started := time.Now()
+	run := &models.Run{
+		ID:      state.NewRunID(started),
+		Command: command,
+		Service: service,
+		Process: process,
+		Config:  a.configPath,
+		User:    currentUser(),
+		Args:    os.Args,
+		Started: started,
+	}
+
+	var mu sync.Mutex
+	ctx = executor.WithRecorder(ctx, func(record models.CommandRecord) {
+		mu.Lock()
+		defer mu.Unlock()
+		run.Commands = append(run.Commands, record)
+	})
+	ctx = executor.WithOutputDir(ctx, a.store.OutputDir(run.ID))
+	return executor.WithLabels(ctx, executor.Labels{RunID: run.ID}), run
+}
+
+// finishRun completes run with its results and saves it. A run that could
+// not be saved is logged, but does not fail the command.
+func (a *App) finishRun(run *models.Run, results []models.RunResult, err error) {
+	if run == nil {
+		return
+	}
+
+	run.Finished = time.Now()
+	run.Results = results
+	run.Status = RunSucceeded
+	for _, result := range results {
+		if !(scheduler.Result{Status: scheduler.Status(result.Status)}).Succeeded() {
+			run.Status = RunFailed
+		}
+	}
+	if err != nil {
+		run.Status = RunFailed
+		run.Error = err.Error()
+	}
+
+	if err := a.store.Save(run); err != nil {
+		a.logger.With("run", run.ID).Errorf("Could not record run %s: %v", run.ID, err)
+	}
+}
+
+// runResults converts the results of a scheduler run for the state store.
+func runResults(results []scheduler.Result) []models.RunResult {
+	var runResults []models.RunResult
+	for _, result := range results {
+		runResult := models.RunResult{
+			Name:     result.Service.Name,
+			Status:   string(result.Status),
+			Duration: result.Duration,
+			Notes:    result.Notes,
+		}
+		if result.Err != nil {
+			runResult.Error = result.Err.Error()
+		}
+		runResults = append(runResults, runResult)
+	}
+	return runResults
+}
+
+// batchRunResults converts the results of a rolling restart for the state
+// store, naming each batch after the service.
+func batchRunResults(serviceName string, results []BatchResult) []models.RunResult {
+	var runResults []models.RunResult
+	for i, result := range results {
+		runResult := models.RunResult{
+			Name:     fmt.Sprintf("%s batch %d %v", serviceName, i+1, result.Processes),
+			Status:   string(result.Status),
+			Duration: result.Duration,
+		}
+		if result.Err != nil {
+			runResult.Error = result.Err.Error()
+		}
+		runResults = append(runResults, runResult)
+	}
+	return runResults
+}
+
+// currentUser returns the name of the OS user running big-brother.
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
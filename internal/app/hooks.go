@@ -0,0 +1,83 @@
+package app
+
+import (
+	"big-brother/internal/executor"
+	"big-brother/internal/models"
+	"big-brother/internal/scheduler"
+	"context"
+	"fmt"
+)
+
+// Hook names, as used in the config and in messages.
+const (
+	hookPreStart  = "pre_start"
+	hookPostStart = "post_start"
+	hookPreStop   = "pre_stop"
+	hookPostStop  = "post_stop"
+)
+
+// hook returns the named hook out of hooks, nil when it is not configured.
+func hook(hooks models.Hooks, name string) *models.Hook {
+	switch name {
+	case hookPreStart:
+		return hooks.PreStart
+	case hookPostStart:
+		return hooks.PostStart
+	case hookPreStop:
+		return hooks.PreStop
+	case hookPostStop:
+		return hooks.PostStop
+	}
+	return nil
+}
+
+// hookProcess is the process a hook runs as: the process it belongs to, or
+// for service hooks a process named after the service, moved to the hook's
+// host and bounded by the hook's timeout. Hooks are never retried.
+func hookProcess(h *models.Hook, owner models.Process) models.Process {
+	owner.HostName = h.HostName
+	owner.Shell = owner.Shell || h.Shell
+	owner.Timeout = h.Timeout
+	owner.Retry = nil
+	return owner
+}
+
+// runProcessHook runs the named hook of process, if it has one.
+func (a *App) runProcessHook(ctx context.Context, process *models.Process, name string) error {
+	h := hook(process.Hooks, name)
+	if h == nil {
+		return nil
+	}
+	owner := fmt.Sprintf("process %s on host %s", process.Name, process.HostName)
+	return a.runHook(ctx, h, name, owner, hookProcess(h, *process))
+}
+
+// runServiceHook runs the named hook of service, if it has one.
+func (a *App) runServiceHook(ctx context.Context, service *models.Service, name string) error {
+	h := hook(service.Hooks, name)
+	if h == nil {
+		return nil
+	}
+	owner := "service " + service.Name
+	return a.runHook(ctx, h, name, owner, hookProcess(h, models.Process{Name: service.Name}))
+}
+
+// runHook runs a hook through the executor. A failing hook is an error unless
+// its on_failure is warn, in which case the failure is logged and noted on
+// the service's result.
+func (a *App) runHook(ctx context.Context, h *models.Hook, name, owner string, process models.Process) error {
+	a.log(ctx).Infof("Running %s hook of %s", name, owner)
+	ctx = executor.WithLabels(ctx, executor.Labels{Action: name})
+	_, err := a.Executor.ExecuteProcessCommand(ctx, &process, h.Cmd)
+	if err == nil {
+		return nil
+	}
+
+	err = fmt.Errorf("%s hook of %s failed: %w", name, owner, err)
+	if h.OnFailure != models.HookWarn || ctx.Err() != nil {
+		return err
+	}
+	a.log(ctx).Warnf("%v, continuing", err)
+	scheduler.Note(ctx, "%s hook of %s failed", name, owner)
+	return nil
+}
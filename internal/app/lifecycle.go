@@ -0,0 +1,214 @@
+package app
+
+import (
+	"big-brother/internal/executor"
+	"big-brother/internal/models"
+	"big-brother/internal/scheduler"
+	"context"
+	"os"
+	"syscall"
+)
+
+// The lifecycle engine. Every command that starts or stops something, whether
+// everything, a service or a single process, restarts and rollbacks included,
+// goes through the functions below, so a process is handled the same way
+// whichever entry point reached it.
+
+func (a *App) startService(ctx context.Context, service *models.Service) error {
+	return a.startProcesses(ctx, service, service.Processes)
+}
+
+func (a *App) stopService(ctx context.Context, service *models.Service) error {
+	return a.stopProcesses(ctx, service, service.Processes)
+}
+
+// startProcesses starts the given processes of service one after another,
+// skipping those already running. The service's pre_start hook runs before the
+// first process that is actually started and its post_start hook after the
+// last one. When all of them were skipped it returns scheduler.Satisfied.
+func (a *App) startProcesses(ctx context.Context, service *models.Service, processes []models.Process) error {
+	a.log(ctx).Infof("Starting service: %s", service.Name)
+	ctx = executor.WithLabels(ctx, executor.Labels{Service: service.Name})
+	ctx = startingService(ctx, service)
+
+	started := 0
+	for _, process := range processes {
+		if a.alreadyIn(ctx, &process, models.StateRunning) {
+			continue
+		}
+		if started == 0 {
+			if err := a.runServiceHook(ctx, service, hookPreStart); err != nil {
+				return err
+			}
+		}
+		started++
+		if err := a.startProcess(ctx, &process); err != nil {
+			return err
+		}
+	}
+	if len(processes) > 0 && started == 0 {
+		return scheduler.Satisfied{Status: scheduler.StatusAlreadyRunning}
+	}
+	if started > 0 {
+		if err := a.runServiceHook(ctx, service, hookPostStart); err != nil {
+			return err
+		}
+	}
+
+	a.log(ctx).Infof("Service %s started successfully.", service.Name)
+	return nil
+}
+
+// stopProcesses stops the given processes of service one after another,
+// skipping those already stopped. The service's pre_stop and post_stop hooks
+// run around the processes that are actually stopped. When all of them were
+// skipped it returns scheduler.Satisfied.
+func (a *App) stopProcesses(ctx context.Context, service *models.Service, processes []models.Process) error {
+	a.log(ctx).Infof("Stopping service: %s", service.Name)
+	ctx = executor.WithLabels(ctx, executor.Labels{Service: service.Name})
+
+	stopped := 0
+	for _, process := range processes {
+		if a.alreadyIn(ctx, &process, models.StateStopped) {
+			continue
+		}
+		if stopped == 0 {
+			if err := a.runServiceHook(ctx, service, hookPreStop); err != nil {
+				return err
+			}
+		}
+		stopped++
+		if err := a.stopProcess(ctx, &process); err != nil {
+			return err
+		}
+	}
+	if len(processes) > 0 && stopped == 0 {
+		return scheduler.Satisfied{Status: scheduler.StatusAlreadyStopped}
+	}
+	if stopped > 0 {
+		if err := a.runServiceHook(ctx, service, hookPostStop); err != nil {
+			return err
+		}
+	}
+
+	a.log(ctx).Infof("Service %s stopped successfully.", service.Name)
+	return nil
+}
+
+// alreadyIn reports whether the process is known to be in state already, in
+// which case starting or stopping it can be skipped. It is always false with
+// force, and when the status cannot be determined.
+func (a *App) alreadyIn(ctx context.Context, process *models.Process, state models.ProcessState) bool {
+	if a.force {
+		return false
+	}
+	ctx = processContext(ctx, process)
+
+	current, err := a.Executor.CheckProcess(ctx, process)
+	if err != nil {
+		a.log(ctx).Warnf("Could not check process %s on host %s, running anyway: %v", process.Name, process.HostName, err)
+		return false
+	}
+	if current == state || (state == models.StateRunning && executor.IsUp(current)) {
+		a.log(ctx).Infof("Process %s on host %s is already %s, skipping.", process.Name, process.HostName, current)
+		return true
+	}
+	return false
+}
+
+// startProcess runs the start command of a process between its pre_start and
+// post_start hooks, and waits for it to be running before the post_start hook.
+func (a *App) startProcess(ctx context.Context, process *models.Process) error {
+	ctx = processContext(ctx, process)
+	if err := a.runProcessHook(ctx, process, hookPreStart); err != nil {
+		return err
+	}
+
+	a.log(ctx).Infof("Starting process: %s on host: %s", process.Name, process.HostName)
+	if err := a.runAction(ctx, process, executor.ActionStart); err != nil {
+		return err
+	}
+	noteStarted(ctx, process)
+
+	// Wait for the process to start
+	if err := a.Executor.WaitForState(ctx, process, models.StateRunning); err != nil {
+		return err
+	}
+	return a.runProcessHook(ctx, process, hookPostStart)
+}
+
+// stopProcess stops a process between its pre_stop and post_stop hooks.
+func (a *App) stopProcess(ctx context.Context, process *models.Process) error {
+	ctx = processContext(ctx, process)
+	if err := a.runProcessHook(ctx, process, hookPreStop); err != nil {
+		return err
+	}
+	if err := a.terminate(ctx, process); err != nil {
+		return err
+	}
+	return a.runProcessHook(ctx, process, hookPostStop)
+}
+
+// terminate runs the stop command of a process and waits up to its
+// stop_timeout for it to be stopped. If it is still up, the stop escalates to
+// kill_cmd or, for local processes with a pid file, to SIGTERM and then
+// SIGKILL, each step again waiting up to stop_timeout. Escalations are noted
+// on the service's result.
+func (a *App) terminate(ctx context.Context, process *models.Process) error {
+	a.log(ctx).Infof("Stopping process: %s on host: %s", process.Name, process.HostName)
+	if err := a.runAction(ctx, process, executor.ActionStop); err != nil {
+		return err
+	}
+
+	// Wait for the process to stop
+	err := a.Executor.WaitForStateWithin(ctx, process, models.StateStopped, process.StopTimeout)
+	if err == nil || ctx.Err() != nil {
+		return err
+	}
+
+	if executor.HasKillCommand(process) {
+		a.escalated(ctx, process, "ran kill_cmd")
+		if err := a.runAction(ctx, process, executor.ActionKill); err != nil {
+			return err
+		}
+		return a.Executor.WaitForStateWithin(ctx, process, models.StateStopped, process.StopTimeout)
+	}
+
+	signals := []struct {
+		sig  os.Signal
+		name string
+	}{
+		{syscall.SIGTERM, "SIGTERM"},
+		{os.Kill, "SIGKILL"},
+	}
+	for _, signal := range signals {
+		sent, signalErr := a.Executor.SignalProcess(process, signal.sig)
+		if signalErr != nil {
+			return signalErr
+		}
+		if !sent {
+			return err
+		}
+		a.escalated(ctx, process, "sent "+signal.name)
+		if err = a.Executor.WaitForStateWithin(ctx, process, models.StateStopped, process.StopTimeout); err == nil || ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// runAction runs the command for action of process, noting on the service's
+// result when it only succeeded after retries.
+func (a *App) runAction(ctx context.Context, process *models.Process, action string) error {
+	result, err := a.Executor.RunProcessActionResult(ctx, process, action)
+	if err == nil && result.Attempts > 1 {
+		scheduler.Note(ctx, "%s@%s: %s succeeded after %d attempts", process.Name, process.HostName, action, result.Attempts)
+	}
+	return err
+}
+
+// escalated records that stopping process needed more than its stop_cmd.
+func (a *App) escalated(ctx context.Context, process *models.Process, step string) {
+	a.log(ctx).Warnf("Process %s on host %s still running %ds after stop, %s", process.Name, process.HostName, process.StopTimeout, step)
+	scheduler.Note(ctx, "%s@%s: %s", process.Name, process.HostName, step)
+}
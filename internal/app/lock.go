@@ -0,0 +1,81 @@
+package app
+
+import (
+	"big-brother/internal/lock"
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultRemoteLockDir is where lock files go on a lock host when the
+	// config gives no path.
+	defaultRemoteLockDir = "/tmp/big-brother"
+	// defaultLockTimeout bounds the lock commands on a lock host, in seconds,
+	// when the config sets no timeout.
+	defaultLockTimeout = 30
+)
+
+// Lock takes the run lock of the config, waiting up to wait while someone
+// else holds it. The lock is a file on lock.host_name when the config sets
+// one, and otherwise a local file, by default in a directory of the temp
+// directory shared by all users of the machine.
+func (a *App) Lock(ctx context.Context, wait time.Duration) (*lock.Lock, error) {
+	backend := a.lockBackend()
+	holder := lock.NewHolder(currentUser(), os.Args)
+	return lock.Acquire(ctx, backend, holder, wait, func(current lock.Holder) {
+		a.log(ctx).Warnf("Waiting up to %s for the lock held by %s", wait, current)
+	})
+}
+
+// ForceUnlock removes the run lock whoever holds it, and returns who did.
+func (a *App) ForceUnlock(ctx context.Context) (*lock.Holder, error) {
+	return a.lockBackend().ForceUnlock(ctx)
+}
+
+func (a *App) lockBackend() lock.Backend {
+	settings := a.config.Lock
+	if settings.HostName != "" {
+		timeout := a.config.Timeout
+		if timeout <= 0 {
+			timeout = defaultLockTimeout
+		}
+		lockPath := settings.Path
+		if lockPath == "" {
+			lockPath = path.Join(defaultRemoteLockDir, a.lockName())
+		}
+		return lock.RemoteBackend{
+			Executor: a.Executor,
+			HostName: settings.HostName,
+			Path:     lockPath,
+			Timeout:  timeout,
+			Shared:   settings.Path == "",
+		}
+	}
+
+	if settings.Path != "" {
+		return lock.FileBackend{Path: settings.Path}
+	}
+	return lock.FileBackend{Path: filepath.Join(defaultLocalLockDir(), a.lockName()), Shared: true}
+}
+
+// defaultLocalLockDir is where local lock files go when the config gives no
+// path. It is the same for every user, so that operators with their own
+// accounts on a machine share the lock.
+func defaultLocalLockDir() string {
+	return filepath.Join(os.TempDir(), "big-brother")
+}
+
+// lockName names the lock file after lock.name, or else after the config
+// file, so that every environment has its own lock and operators working from
+// their own copies of the config share it.
+func (a *App) lockName() string {
+	if a.config.Lock.Name != "" {
+		return a.config.Lock.Name + ".lock"
+	}
+	base := filepath.Base(a.configPath)
+	return strings.TrimSuffix(base, filepath.Ext(base)) + ".lock"
+}
@@ -0,0 +1,131 @@
+package app
+
+import (
+	"big-brother/internal/executor"
+	"big-brother/internal/models"
+	"big-brother/internal/scheduler"
+	"big-brother/internal/utils"
+	"fmt"
+)
+
+// Plan works out what start, stop or restart would do for the given target
+// (everything, a service, or a process of a service) without running any
+// command. Services are grouped by dependency level, alongside the number of
+// services the scheduler would work on at once.
+func (a *App) Plan(command, serviceName, processName string) (*models.Plan, error) {
+	services := a.config.StartOrder
+	var target *models.Service
+	var process *models.Process
+	if serviceName != "" {
+		var err error
+		if target, err = utils.FindServiceByName(a.config, serviceName); err != nil {
+			return nil, err
+		}
+		if processName != "" {
+			if process, err = utils.FindProcessByName(target, processName); err != nil {
+				return nil, err
+			}
+		}
+		services = []*models.Service{target}
+		if command == "restart" {
+			services = utils.WithDependents(a.config.StartOrder, target)
+		}
+	}
+
+	var phases []string
+	switch command {
+	case "start":
+		phases = []string{executor.ActionStart}
+	case "stop":
+		phases = []string{executor.ActionStop}
+	case "restart":
+		phases = []string{executor.ActionStop, executor.ActionStart}
+	default:
+		return nil, fmt.Errorf("no plan for command: %s", command)
+	}
+
+	plan := &models.Plan{Command: command, Concurrency: max(a.threadCount, 1)}
+	for _, action := range phases {
+		phase, err := a.planPhase(services, action, target, process)
+		if err != nil {
+			return nil, err
+		}
+		plan.Phases = append(plan.Phases, phase)
+	}
+	return plan, nil
+}
+
+// planPhase renders action for every process of services, level by level. When
+// process is set, target only gets that one process.
+func (a *App) planPhase(services []*models.Service, action string, target *models.Service, process *models.Process) (models.PlanPhase, error) {
+	var prerequisites scheduler.Prerequisites = utils.Dependencies
+	if action == executor.ActionStop {
+		services = reverse(services)
+		prerequisites = utils.Dependents
+	}
+
+	phase := models.PlanPhase{Action: action}
+	for _, level := range utils.Waves(services, prerequisites) {
+		var planned []models.PlannedService
+		for _, service := range level {
+			processes := service.Processes
+			if service == target && process != nil {
+				processes = []models.Process{*process}
+			}
+
+			plannedService, err := a.planService(service, processes, action)
+			if err != nil {
+				return models.PlanPhase{}, err
+			}
+			planned = append(planned, plannedService)
+		}
+		phase.Levels = append(phase.Levels, planned)
+	}
+	return phase, nil
+}
+
+// planService renders action for processes of service, with the hooks of the
+// service and of every process around it.
+func (a *App) planService(service *models.Service, processes []models.Process, action string) (models.PlannedService, error) {
+	pre, post := hookPreStart, hookPostStart
+	if action == executor.ActionStop {
+		pre, post = hookPreStop, hookPostStop
+	}
+
+	plannedService := models.PlannedService{Name: service.Name}
+	addHook := func(hooks models.Hooks, name string, owner models.Process) error {
+		h := hook(hooks, name)
+		if h == nil {
+			return nil
+		}
+		p := hookProcess(h, owner)
+		step, err := a.Executor.PlanProcessCommand(&p, h.Cmd)
+		if err != nil {
+			return fmt.Errorf("%w in %s hook of service: %s", err, name, service.Name)
+		}
+		step.Hook = name
+		plannedService.Steps = append(plannedService.Steps, step)
+		return nil
+	}
+
+	if err := addHook(service.Hooks, pre, models.Process{Name: service.Name}); err != nil {
+		return models.PlannedService{}, err
+	}
+	for _, p := range processes {
+		if err := addHook(p.Hooks, pre, p); err != nil {
+			return models.PlannedService{}, err
+		}
+		step, err := a.Executor.PlanProcessAction(&p, action)
+		if err != nil {
+			return models.PlannedService{}, fmt.Errorf("%w in process: %s in service: %s", err, p.Name, service.Name)
+		}
+		plannedService.Steps = append(plannedService.Steps, step)
+		if err := addHook(p.Hooks, post, p); err != nil {
+			return models.PlannedService{}, err
+		}
+	}
+	if err := addHook(service.Hooks, post, models.Process{Name: service.Name}); err != nil {
+		return models.PlannedService{}, err
+	}
+	return plannedService, nil
+}
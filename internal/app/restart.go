@@ -0,0 +1,114 @@
+package app
+
+import (
+	"big-brother/internal/models"
+	"big-brother/internal/scheduler"
+	"big-brother/internal/utils"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// RestartAll stops every service leaf-first and then starts them all again
+// root-first.
+func (a *App) RestartAll(ctx context.Context) []scheduler.Result {
+	ctx, run := a.beginRun(ctx, "restart", "", "")
+	a.log(ctx).Info("Restarting all services...")
+
+	results := a.restart(ctx, a.config.StartOrder, a.stopService, a.startService)
+	a.finishRun(run, runResults(results), nil)
+	return results
+}
+
+// RestartService restarts a service together with everything that depends on
+// it: dependents are stopped before the service and started after it.
+func (a *App) RestartService(ctx context.Context, serviceName string) (results []scheduler.Result, err error) {
+	ctx, run := a.beginRun(ctx, "restart", serviceName, "")
+	a.log(ctx).Infof("Restarting service: %s", serviceName)
+	defer func() { a.finishRun(run, runResults(results), err) }()
+
+	service, err := utils.FindServiceByName(a.config, serviceName)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.checkDependencies(ctx, service); err != nil {
+		return nil, err
+	}
+
+	return a.restart(ctx, utils.WithDependents(a.config.StartOrder, service), a.stopService, a.startService), nil
+}
+
+// RestartProcess restarts a single process, stopping the services that
+// depend on its service first and starting them again afterwards.
+func (a *App) RestartProcess(ctx context.Context, serviceName, processName string) (results []scheduler.Result, err error) {
+	ctx, run := a.beginRun(ctx, "restart", serviceName, processName)
+	a.log(ctx).Infof("Restarting process: %s in service: %s", processName, serviceName)
+	defer func() { a.finishRun(run, runResults(results), err) }()
+
+	service, err := utils.FindServiceByName(a.config, serviceName)
+	if err != nil {
+		return nil, err
+	}
+
+	process, err := utils.FindProcessByName(service, processName)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.checkDependencies(ctx, service); err != nil {
+		return nil, err
+	}
+
+	// The target service only has the one process restarted
+	stop := func(ctx context.Context, s *models.Service) error {
+		if s == service {
+			return a.stopProcesses(ctx, s, []models.Process{*process})
+		}
+		return a.stopService(ctx, s)
+	}
+	start := func(ctx context.Context, s *models.Service) error {
+		if s == service {
+			return a.startProcesses(ctx, s, []models.Process{*process})
+		}
+		return a.startService(ctx, s)
+	}
+	return a.restart(ctx, utils.WithDependents(a.config.StartOrder, service), stop, start), nil
+}
+
+// restart stops services in reverse dependency order and, if they all
+// stopped, starts them again in dependency order. There is one result per
+// service, with the time spent stopping and starting it. If the stop phase
+// fails nothing is started, and the services that did stop are reported as
+// skipped. The start phase is rolled back like a start under the rollback
+// policy, leaving the services stopped.
+func (a *App) restart(ctx context.Context, services []*models.Service, stop, start scheduler.Action) []scheduler.Result {
+	stopped := scheduler.Run(ctx, reverse(services), utils.Dependents, a.threadCount, a.failFast(), stop)
+	if len(scheduler.Failed(stopped)) > 0 {
+		for i := range stopped {
+			if stopped[i].Succeeded() {
+				stopped[i].Status = scheduler.StatusSkipped
+				stopped[i].Err = errors.New("stopped, not started again because the stop phase failed")
+			} else if stopped[i].Err != nil {
+				stopped[i].Err = fmt.Errorf("stop: %w", stopped[i].Err)
+			}
+		}
+		a.logResults(ctx, stopped, "restarted")
+		return stopped
+	}
+
+	stopDurations := make(map[*models.Service]time.Duration)
+	for _, result := range stopped {
+		stopDurations[result.Service] = result.Duration
+	}
+
+	results := a.withRollback(ctx, func(ctx context.Context) []scheduler.Result {
+		return scheduler.Run(ctx, services, utils.Dependencies, a.threadCount, a.failFast(), start)
+	})
+	for i := range results {
+		results[i].Duration += stopDurations[results[i].Service]
+	}
+	a.logResults(ctx, results, "restarted")
+	return results
+}
@@ -0,0 +1,110 @@
+package app
+
+import (
+	"big-brother/internal/models"
+	"big-brother/internal/scheduler"
+	"big-brother/internal/utils"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// startedProcesses records, per service, the processes a run started, so
+// that a rollback stops exactly those: not the ones that were running before
+// the run, but also the ones a service started before it failed.
+type startedProcesses struct {
+	mu        sync.Mutex
+	byService map[*models.Service][]models.Process
+}
+
+type startedKey struct{}
+
+type startingServiceKey struct{}
+
+// withStartedProcesses returns a context under which every process whose
+// start command succeeds is recorded in the returned startedProcesses.
+func withStartedProcesses(ctx context.Context) (context.Context, *startedProcesses) {
+	started := &startedProcesses{byService: make(map[*models.Service][]models.Process)}
+	return context.WithValue(ctx, startedKey{}, started), started
+}
+
+// startingService returns ctx telling noteStarted which service the
+// processes started under it belong to.
+func startingService(ctx context.Context, service *models.Service) context.Context {
+	return context.WithValue(ctx, startingServiceKey{}, service)
+}
+
+// noteStarted records that process was started, if ctx tracks started
+// processes.
+func noteStarted(ctx context.Context, process *models.Process) {
+	started, ok := ctx.Value(startedKey{}).(*startedProcesses)
+	service, _ := ctx.Value(startingServiceKey{}).(*models.Service)
+	if !ok || service == nil {
+		return
+	}
+
+	started.mu.Lock()
+	defer started.mu.Unlock()
+	started.byService[service] = append(started.byService[service], *process)
+}
+
+// withRollback runs a start, and under the rollback policy stops what it
+// started again once anything failed.
+func (a *App) withRollback(ctx context.Context, start func(context.Context) []scheduler.Result) []scheduler.Result {
+	if a.failurePolicy != models.RollbackOnFailure {
+		return start(ctx)
+	}
+	ctx, started := withStartedProcesses(ctx)
+	results := start(ctx)
+	if len(scheduler.Failed(results)) > 0 {
+		a.rollback(ctx, results, started)
+	}
+	return results
+}
+
+// rollback stops the processes the run started, service by service in
+// reverse dependency order. Services that had started are marked rolled back
+// or rollback failed; a failed service keeps its status and gets a note on
+// what was stopped of it.
+func (a *App) rollback(ctx context.Context, results []scheduler.Result, started *startedProcesses) {
+	var services []*models.Service
+	index := make(map[*models.Service]int)
+	for i, result := range results {
+		if len(started.byService[result.Service]) > 0 {
+			services = append(services, result.Service)
+			index[result.Service] = i
+		}
+	}
+	if len(services) == 0 {
+		return
+	}
+
+	a.log(ctx).Warnf("Start failed, rolling back %d started services...", len(services))
+	stop := func(ctx context.Context, service *models.Service) error {
+		return a.stopProcesses(ctx, service, started.byService[service])
+	}
+	for _, stopped := range scheduler.Run(ctx, reverse(services), utils.Dependents, a.threadCount, false, stop) {
+		result := &results[index[stopped.Service]]
+		switch {
+		case result.Status != scheduler.StatusSucceeded && stopped.Succeeded():
+			result.Notes = append(result.Notes, fmt.Sprintf("rolled back %s", processNames(started.byService[stopped.Service])))
+		case result.Status != scheduler.StatusSucceeded:
+			result.Notes = append(result.Notes, fmt.Sprintf("rollback of %s %s: %v", processNames(started.byService[stopped.Service]), stopped.Status, stopped.Err))
+		case stopped.Succeeded():
+			result.Status = scheduler.StatusRolledBack
+		default:
+			result.Status = scheduler.StatusRollbackFailed
+			result.Err = fmt.Errorf("rollback %s: %w", stopped.Status, stopped.Err)
+		}
+	}
+}
+
+// processNames lists processes as name@host.
+func processNames(processes []models.Process) string {
+	var names []string
+	for _, process := range processes {
+		names = append(names, process.Name+"@"+process.HostName)
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,132 @@
+package app
+
+import (
+	"big-brother/internal/executor"
+	"big-brother/internal/models"
+	"big-brother/internal/scheduler"
+	"big-brother/internal/utils"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// BatchResult is the outcome of one batch of a rolling restart. Processes
+// are named process@host.
+type BatchResult struct {
+	Processes []string
+	Status    scheduler.Status
+	Err       error
+	Duration  time.Duration
+}
+
+// RollingRestart restarts the processes of a service in batches of the given
+// size ("2" or "25%"), waiting for every process of a batch to be running
+// again before moving on. The first batch that fails aborts the rest. Each
+// batch runs the service's hooks, see restartBatch.
+func (a *App) RollingRestart(ctx context.Context, serviceName, batchSize string) (results []BatchResult, err error) {
+	ctx, run := a.beginRun(ctx, "rolling-restart", serviceName, "")
+	a.log(ctx).Infof("Rolling restart of service: %s", serviceName)
+	defer func() { a.finishRun(run, batchRunResults(serviceName, results), err) }()
+
+	service, err := utils.FindServiceByName(a.config, serviceName)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := utils.ParseBatchSize(batchSize, len(service.Processes))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.checkDependencies(ctx, service); err != nil {
+		return nil, err
+	}
+
+	var abort error
+	for first := 0; first < len(service.Processes); first += size {
+		batch := service.Processes[first:min(first+size, len(service.Processes))]
+		result := BatchResult{Status: scheduler.StatusSkipped, Err: abort}
+		for _, process := range batch {
+			result.Processes = append(result.Processes, process.Name+"@"+process.HostName)
+		}
+		if abort == nil {
+			if err := ctx.Err(); err != nil {
+				abort = err
+				result.Err = err
+			}
+		}
+		if abort != nil {
+			results = append(results, result)
+			continue
+		}
+
+		a.log(ctx).Infof("Restarting batch %d of service %s: %v", len(results)+1, service.Name, result.Processes)
+		started := time.Now()
+		result.Err = a.restartBatch(executor.WithLabels(ctx, executor.Labels{Service: service.Name}), service, batch)
+		result.Duration = time.Since(started)
+		result.Status = scheduler.StatusSucceeded
+		if result.Err != nil {
+			result.Status = scheduler.StatusFailed
+			abort = fmt.Errorf("rolling restart aborted after batch %d failed", len(results)+1)
+		}
+		results = append(results, result)
+	}
+
+	for _, result := range results {
+		if result.Status != scheduler.StatusSucceeded {
+			a.log(ctx).Errorf("Batch %v %s: %v", result.Processes, result.Status, result.Err)
+		}
+	}
+	return results, nil
+}
+
+// restartBatch stops the given processes of service in parallel, then starts
+// again those that stopped, and returns the joined errors of those that did
+// not come back up. The service's stop hooks run around the stops and its
+// start hooks around the starts, as for any other stop and start; a failing
+// hook ends the batch.
+func (a *App) restartBatch(ctx context.Context, service *models.Service, batch []models.Process) error {
+	if err := a.runServiceHook(ctx, service, hookPreStop); err != nil {
+		return err
+	}
+	stopErrs := a.eachProcess(ctx, batch, a.stopProcess)
+	var stopped []models.Process
+	for i, err := range stopErrs {
+		if err == nil {
+			stopped = append(stopped, batch[i])
+		}
+	}
+	if len(stopped) == 0 {
+		return errors.Join(stopErrs...)
+	}
+
+	if err := a.runServiceHook(ctx, service, hookPostStop); err != nil {
+		return errors.Join(append(stopErrs, err)...)
+	}
+	if err := a.runServiceHook(ctx, service, hookPreStart); err != nil {
+		return errors.Join(append(stopErrs, err)...)
+	}
+	errs := append(stopErrs, a.eachProcess(ctx, stopped, a.startProcess)...)
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	return a.runServiceHook(ctx, service, hookPostStart)
+}
+
+// eachProcess runs action on the given processes in parallel and returns
+// their errors, in the order of processes.
+func (a *App) eachProcess(ctx context.Context, processes []models.Process, action func(context.Context, *models.Process) error) []error {
+	errs := make([]error, len(processes))
+	var wg sync.WaitGroup
+	for i := range processes {
+		wg.Add(1)
+		go func(process *models.Process) {
+			defer wg.Done()
+			errs[i] = action(ctx, process)
+		}(&processes[i])
+	}
+	wg.Wait()
+	return errs
+}
@@ -0,0 +1,239 @@
+package app
+
+import (
+	"big-brother/internal/executor"
+	"big-brother/internal/lock"
+	"big-brother/internal/models"
+	"big-brother/internal/scheduler"
+	"context"
+	"fmt"
+	"time"
+)
+
+// Supervisor keeps checking every process and restarts the stopped ones as
+// their restart_policy allows, in dependency order. A service whose
+// dependencies are down waits for them to recover, and once a service was
+// restarted its dependents are restarted too.
+type Supervisor struct {
+	app     *App
+	watches map[string]*watch
+}
+
+// watch is what the supervisor remembers about a process between checks.
+type watch struct {
+	seenUp   bool
+	gaveUp   bool
+	restarts []time.Time
+}
+
+func (a *App) NewSupervisor() *Supervisor {
+	return &Supervisor{
+		app:     a,
+		watches: make(map[string]*watch),
+	}
+}
+
+// Run checks everything every interval, or every check_interval from the
+// config when interval is not positive, until ctx is cancelled. The results of
+// every check that restarted something are passed to report.
+func (s *Supervisor) Run(ctx context.Context, interval time.Duration, report func([]scheduler.Result)) {
+	if interval <= 0 {
+		interval = time.Duration(s.app.config.CheckInterval) * time.Second
+	}
+	s.app.log(ctx).Infof("Supervising all services, checking every %s", interval)
+
+	for {
+		if results := s.Check(ctx); len(results) > 0 {
+			report(results)
+		}
+		if err := executor.Sleep(ctx, interval); err != nil {
+			return
+		}
+	}
+}
+
+// Check checks every process once and restarts what needs restarting. It
+// returns one result per restarted service.
+func (s *Supervisor) Check(ctx context.Context) []scheduler.Result {
+	runLock, err := s.app.Lock(ctx, 0)
+	if lock.IsLocked(err) {
+		// Someone is working on the services; what they stopped on purpose
+		// must not count as a failure afterwards
+		s.app.log(ctx).Infof("Skipping check: %v", err)
+		s.watches = make(map[string]*watch)
+		return nil
+	}
+	if err != nil {
+		s.app.log(ctx).Errorf("Skipping check: %v", err)
+		return nil
+	}
+	defer func() {
+		if err := runLock.Unlock(context.WithoutCancel(ctx)); err != nil {
+			s.app.log(ctx).Errorf("%v", err)
+		}
+	}()
+
+	ctx, run := s.app.beginRun(ctx, "supervise", "", "")
+	var results []scheduler.Result
+	down := make(map[*models.Service]bool)
+	recovered := make(map[*models.Service]bool)
+
+	for _, service := range s.app.config.StartOrder {
+		if ctx.Err() != nil {
+			break
+		}
+
+		ctx := executor.WithLabels(ctx, executor.Labels{Service: service.Name})
+
+		// Everything else is restarted as well once a dependency came back
+		dependencyRecovered := s.dependencyRecovered(service, recovered)
+		var stopped, running, targets []models.Process
+		left := 0
+		for _, process := range service.Processes {
+			w := s.watch(service, &process)
+			state, err := s.app.Executor.CheckProcess(ctx, &process)
+			switch {
+			case err != nil || state == models.StateUnknown || state == models.StateTimedOut:
+				s.app.log(processContext(ctx, &process)).Warnf("Could not check process %s on host %s, leaving it alone: state %s, %v", process.Name, process.HostName, state, err)
+			case executor.IsUp(state):
+				w.seenUp = true
+				w.gaveUp = false
+				if dependencyRecovered && restartable(&process) {
+					running = append(running, process)
+					targets = append(targets, process)
+				}
+			case s.shouldRestart(ctx, &process, w):
+				stopped = append(stopped, process)
+				targets = append(targets, process)
+			default:
+				left++
+			}
+		}
+
+		if waitingFor := s.downDependency(service, down); waitingFor != nil {
+			if len(stopped) > 0 || left > 0 {
+				s.app.log(ctx).Warnf("Service %s waits for %s to recover", service.Name, waitingFor.Name)
+				down[service] = true
+			}
+			continue
+		}
+		if len(targets) == 0 {
+			down[service] = left > 0
+			continue
+		}
+
+		for _, process := range stopped {
+			s.app.log(processContext(ctx, &process)).Warnf("Process %s on host %s is stopped, restarting", process.Name, process.HostName)
+			w := s.watch(service, &process)
+			w.restarts = append(w.restarts, time.Now())
+		}
+		result := s.restart(ctx, service, running, targets)
+		results = append(results, result...)
+		if len(scheduler.Failed(result)) > 0 {
+			down[service] = true
+			continue
+		}
+		for _, process := range stopped {
+			s.watch(service, &process).seenUp = true
+		}
+		recovered[service] = true
+		down[service] = left > 0
+	}
+
+	// Checks that restarted nothing are not worth recording
+	if len(results) > 0 {
+		s.app.finishRun(run, runResults(results), nil)
+	}
+	return results
+}
+
+// restart stops the running processes of service and then starts all of
+// targets.
+func (s *Supervisor) restart(ctx context.Context, service *models.Service, running, targets []models.Process) []scheduler.Result {
+	return s.app.runOne(ctx, service, func(ctx context.Context, service *models.Service) error {
+		if len(running) > 0 {
+			if err := s.app.stopProcesses(ctx, service, running); err != nil {
+				return fmt.Errorf("stop: %w", err)
+			}
+		}
+		return s.app.startProcesses(ctx, service, targets)
+	})
+}
+
+func (s *Supervisor) watch(service *models.Service, process *models.Process) *watch {
+	key := service.Name + "/" + process.Name
+	w, ok := s.watches[key]
+	if !ok {
+		w = &watch{}
+		s.watches[key] = w
+	}
+	return w
+}
+
+// shouldRestart decides whether a stopped process is restarted now. Once a
+// process used up its restarts within the window it is given up on until it is
+// seen running again.
+func (s *Supervisor) shouldRestart(ctx context.Context, process *models.Process, w *watch) bool {
+	policy := process.Restart
+	if !restartable(process) || (policy.Mode == models.RestartOnFailure && !w.seenUp) || w.gaveUp {
+		return false
+	}
+
+	now := time.Now()
+	window := now.Add(-time.Duration(policy.Window) * time.Second)
+	for len(w.restarts) > 0 && w.restarts[0].Before(window) {
+		w.restarts = w.restarts[1:]
+	}
+	if len(w.restarts) >= policy.MaxRestarts {
+		s.app.log(processContext(ctx, process)).Errorf("Process %s on host %s was restarted %d times within %ds, giving up", process.Name, process.HostName, len(w.restarts), policy.Window)
+		w.gaveUp = true
+		return false
+	}
+	if len(w.restarts) > 0 {
+		next := w.restarts[len(w.restarts)-1].Add(restartDelay(policy, len(w.restarts)))
+		if now.Before(next) {
+			s.app.log(processContext(ctx, process)).Infof("Process %s on host %s is stopped, restarting after %s", process.Name, process.HostName, next.Sub(now).Round(time.Second))
+			return false
+		}
+	}
+	return true
+}
+
+// restartable reports whether the supervisor may restart process at all.
+func restartable(process *models.Process) bool {
+	return process.Restart != nil && process.Restart.Mode != models.RestartNever
+}
+
+// restartDelay returns the least time between the given restart, counting
+// from 1, and the next one.
+func restartDelay(policy *models.RestartPolicy, restarts int) time.Duration {
+	delay := time.Duration(policy.Delay) * time.Second
+	if policy.Backoff == models.BackoffExponential {
+		delay <<= min(restarts-1, 20)
+	}
+	if policy.MaxDelay > 0 {
+		delay = min(delay, time.Duration(policy.MaxDelay)*time.Second)
+	}
+	return delay
+}
+
+// downDependency returns a dependency of service that is down, if any.
+func (s *Supervisor) downDependency(service *models.Service, down map[*models.Service]bool) *models.Service {
+	for _, dependency := range service.Dependencies {
+		if down[dependency] {
+			return dependency
+		}
+	}
+	return nil
+}
+
+// dependencyRecovered reports whether a dependency of service was restarted
+// during this check.
+func (s *Supervisor) dependencyRecovered(service *models.Service, recovered map[*models.Service]bool) bool {
+	for _, dependency := range service.Dependencies {
+		if recovered[dependency] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,130 @@
+package audit
+
+import (
+	"big-brother/internal/models"
+	"big-brother/internal/rotate"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry is one executed command in the audit trail.
+type Entry struct {
+	Time        time.Time `json:"time"`
+	User        string    `json:"user"`
+	CommandLine string    `json:"command_line"`
+	RunID       string    `json:"run_id,omitempty"`
+	Service     string    `json:"service,omitempty"`
+	Process     string    `json:"process,omitempty"`
+	Action      string    `json:"action,omitempty"`
+	Host        string    `json:"host"`
+	Runner      string    `json:"runner"`
+	Command     string    `json:"command"`
+	ExitCode    int       `json:"exit_code"`
+	DurationMS  int64     `json:"duration_ms"`
+	Output      string    `json:"output,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// Sink receives audit entries, one JSON line at a time.
+type Sink interface {
+	Write(line []byte) error
+	Close() error
+}
+
+// Log writes audit entries to its sinks. It is safe for concurrent use.
+type Log struct {
+	mu          sync.Mutex
+	sinks       []Sink
+	user        string
+	commandLine string
+	outputLimit int
+	status      bool
+}
+
+// New opens the sinks configured in cfg for entries made on behalf of user
+// running args. It returns nil when cfg enables no sink.
+func New(cfg models.AuditConfig, user string, args []string) (*Log, error) {
+	l := &Log{
+		user:        user,
+		commandLine: strings.Join(args, " "),
+		outputLimit: cfg.OutputLimit,
+		status:      cfg.Status,
+	}
+	if cfg.File != "" {
+		file, err := rotate.Open(cfg.File, int64(cfg.MaxSize)<<20, cfg.MaxFiles)
+		if err != nil {
+			return nil, err
+		}
+		l.sinks = append(l.sinks, fileSink{file})
+	}
+	if cfg.Syslog {
+		syslog, err := OpenSyslog(cfg.SyslogTag)
+		if err != nil {
+			l.Close()
+			return nil, err
+		}
+		l.sinks = append(l.sinks, syslog)
+	}
+	if len(l.sinks) == 0 {
+		return nil, nil
+	}
+	return l, nil
+}
+
+// Wants reports whether commands for action belong in the audit trail.
+// Status checks only do when the config asks for them.
+func (l *Log) Wants(action string) bool {
+	return l.status || action != "status"
+}
+
+// Record fills in who ran the command, truncates its output and writes entry
+// to every sink.
+func (l *Log) Record(entry Entry) error {
+	entry.User = l.user
+	entry.CommandLine = l.commandLine
+	if len(entry.Output) > l.outputLimit {
+		entry.Output = entry.Output[:l.outputLimit] + "...(truncated)"
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var errs []error
+	for _, sink := range l.sinks {
+		errs = append(errs, sink.Write(line))
+	}
+	return errors.Join(errs...)
+}
+
+// fileSink writes audit lines to a rotated file.
+type fileSink struct {
+	file *rotate.File
+}
+
+func (f fileSink) Write(line []byte) error {
+	if _, err := f.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("error writing audit log: %w", err)
+	}
+	return nil
+}
+
+func (f fileSink) Close() error {
+	return f.file.Close()
+}
+
+// Close closes every sink.
+func (l *Log) Close() error {
+	var errs []error
+	for _, sink := range l.sinks {
+		errs = append(errs, sink.Close())
+	}
+	return errors.Join(errs...)
+}
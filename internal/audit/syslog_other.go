@@ -0,0 +1,21 @@
+//go:build windows || plan9
+
+package audit
+
+import "errors"
+
+// Syslog is not available on this platform.
+type Syslog struct{}
+
+// OpenSyslog always fails, as there is no syslog on this platform.
+func OpenSyslog(tag string) (*Syslog, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
+
+func (s *Syslog) Write(line []byte) error {
+	return nil
+}
+
+func (s *Syslog) Close() error {
+	return nil
+}
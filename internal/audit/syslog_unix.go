@@ -0,0 +1,30 @@
+//go:build !windows && !plan9
+
+package audit
+
+import (
+	"fmt"
+	"log/syslog"
+)
+
+// Syslog sends audit lines to the local syslog daemon.
+type Syslog struct {
+	writer *syslog.Writer
+}
+
+// OpenSyslog connects to the local syslog daemon, logging with the given tag.
+func OpenSyslog(tag string) (*Syslog, error) {
+	writer, err := syslog.New(syslog.LOG_NOTICE|syslog.LOG_AUTH, tag)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to syslog: %w", err)
+	}
+	return &Syslog{writer: writer}, nil
+}
+
+func (s *Syslog) Write(line []byte) error {
+	return s.writer.Notice(string(line))
+}
+
+func (s *Syslog) Close() error {
+	return s.writer.Close()
+}
@@ -0,0 +1,185 @@
+package executor
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Command is a command ready to hand to a Runner: either an exact argv list,
+// optionally preceded by environment assignments, or a line for /bin/sh -c.
+type Command struct {
+	Argv  []string
+	Env   []string
+	Shell bool
+	Line  string
+}
+
+var envAssignment = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+
+// ParseCommand turns a configured command line into a Command. With shell set
+// the line is passed to /bin/sh untouched; otherwise it is split with POSIX
+// quoting rules, leading NAME=value words become environment variables, and
+// unquoted shell operators are rejected.
+func ParseCommand(line string, shell bool) (Command, error) {
+	if strings.TrimSpace(line) == "" {
+		return Command{}, fmt.Errorf("invalid command: %s", line)
+	}
+	if shell {
+		return Command{Shell: true, Line: line}, nil
+	}
+
+	words, plainPrefixes, err := splitWords(line)
+	if err != nil {
+		return Command{}, err
+	}
+
+	var cmd Command
+	for i, word := range words {
+		if len(cmd.Argv) == 0 && envAssignment.MatchString(plainPrefixes[i]) {
+			cmd.Env = append(cmd.Env, word)
+			continue
+		}
+		cmd.Argv = append(cmd.Argv, word)
+	}
+	if len(cmd.Argv) == 0 {
+		return Command{}, fmt.Errorf("invalid command: %s", line)
+	}
+	return cmd, nil
+}
+
+// ArgvCommand wraps an exact argv list.
+func ArgvCommand(argv []string) Command {
+	return Command{Argv: argv}
+}
+
+// String renders the command as a single shell line, quoting where needed.
+// Remote runners send this line to the remote shell.
+func (c Command) String() string {
+	if c.Shell {
+		return c.Line
+	}
+	words := make([]string, 0, len(c.Env)+len(c.Argv))
+	for _, env := range c.Env {
+		name, value, _ := strings.Cut(env, "=")
+		words = append(words, name+"="+QuoteWord(value))
+	}
+	for _, arg := range c.Argv {
+		words = append(words, QuoteWord(arg))
+	}
+	return strings.Join(words, " ")
+}
+
+// argv returns the argv to execute, going through /bin/sh for shell commands.
+func (c Command) argv() []string {
+	if c.Shell {
+		return []string{"/bin/sh", "-c", c.Line}
+	}
+	return c.Argv
+}
+
+// QuoteWord quotes word for a POSIX shell, leaving it alone when it is safe
+// as is.
+func QuoteWord(word string) string {
+	if word == "" {
+		return "''"
+	}
+	safe := true
+	for _, r := range word {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("_@%+=:,./-", r)) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return word
+	}
+	return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+}
+
+// splitWords splits line into words following POSIX quoting. Alongside each
+// word it returns the part of the word that preceded any quoting, so callers
+// can tell a NAME=value assignment from a quoted argument.
+func splitWords(line string) ([]string, []string, error) {
+	var words, plainPrefixes []string
+	var word strings.Builder
+	plainPrefix := ""
+	inWord, quoted := false, false
+
+	endWord := func() {
+		if inWord {
+			if !quoted {
+				plainPrefix = word.String()
+			}
+			words = append(words, word.String())
+			plainPrefixes = append(plainPrefixes, plainPrefix)
+		}
+		word.Reset()
+		plainPrefix = ""
+		inWord, quoted = false, false
+	}
+	startQuote := func() {
+		if !quoted {
+			plainPrefix = word.String()
+			quoted = true
+		}
+		inWord = true
+	}
+
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == ' ' || r == '\t':
+			endWord()
+		case r == '\'':
+			startQuote()
+			end := indexRune(runes, i+1, '\'')
+			if end < 0 {
+				return nil, nil, fmt.Errorf("unterminated single quote in command: %s", line)
+			}
+			word.WriteString(string(runes[i+1 : end]))
+			i = end
+		case r == '"':
+			startQuote()
+			i++
+			for ; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) && strings.ContainsRune("$`\"\\\n", runes[i+1]) {
+					i++
+				} else if runes[i] == '$' || runes[i] == '`' {
+					return nil, nil, shellSyntaxError(line)
+				}
+				word.WriteRune(runes[i])
+			}
+			if i >= len(runes) {
+				return nil, nil, fmt.Errorf("unterminated double quote in command: %s", line)
+			}
+		case r == '\\':
+			startQuote()
+			if i+1 < len(runes) {
+				i++
+				word.WriteRune(runes[i])
+			}
+		case strings.ContainsRune("|&;<>()$`\n", r):
+			return nil, nil, shellSyntaxError(line)
+		default:
+			inWord = true
+			word.WriteRune(r)
+		}
+	}
+	endWord()
+	return words, plainPrefixes, nil
+}
+
+func indexRune(runes []rune, from int, target rune) int {
+	for i := from; i < len(runes); i++ {
+		if runes[i] == target {
+			return i
+		}
+	}
+	return -1
+}
+
+func shellSyntaxError(line string) error {
+	return fmt.Errorf("command uses shell syntax, set shell: true to run it through /bin/sh: %s", line)
+}
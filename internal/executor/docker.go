@@ -0,0 +1,40 @@
+package executor
+
+import (
+	"big-brother/internal/models"
+	"context"
+	"os/exec"
+)
+
+// DockerRunner executes commands inside containers with docker exec. The
+// container defaults to the host name unless the host sets container. When
+// ctx is done only the docker exec client is killed; docker offers no way to
+// stop the command it started inside the container, which keeps running.
+type DockerRunner struct {
+	containers map[string]string
+}
+
+func NewDockerRunner(hosts []models.Host) *DockerRunner {
+	containers := make(map[string]string)
+	for _, host := range hosts {
+		if host.Container != "" {
+			containers[host.Name] = host.Container
+		}
+	}
+	return &DockerRunner{containers: containers}
+}
+
+func (r *DockerRunner) Run(ctx context.Context, hostName string, command Command) (Result, error) {
+	container, ok := r.containers[hostName]
+	if !ok {
+		container = hostName
+	}
+
+	args := []string{"exec"}
+	for _, env := range command.Env {
+		args = append(args, "-e", env)
+	}
+	args = append(args, container)
+	args = append(args, command.argv()...)
+	return runCmd(ctx, exec.CommandContext(ctx, "docker", args...))
+}
@@ -1,124 +1,372 @@
 package executor
 
 import (
+	"big-brother/internal/audit"
 	"big-brother/internal/logger"
 	"big-brother/internal/models"
+	"context"
+	"errors"
 	"fmt"
-	"os/exec"
-	"strings"
 	"time"
 )
 
+// ErrTimedOut is returned, wrapped, when a command is killed for exceeding
+// its timeout.
+var ErrTimedOut = errors.New("timed out")
+
 type Executor struct {
-	logger   *logger.Logger
-	waitTime int
+	logger      *logger.Logger
+	audit       *audit.Log
+	stream      *Stream
+	ssh         *SSHRunner
+	runners     map[string]Runner
+	hostRunners map[string]string
 }
 
-func NewExecutor(logger *logger.Logger, waitTime int) *Executor {
-	return &Executor{
-		logger:   logger,
-		waitTime: waitTime,
+func NewExecutor(logger *logger.Logger) *Executor {
+	e := &Executor{
+		logger:      logger,
+		hostRunners: make(map[string]string),
 	}
+	e.ConfigureHosts(models.SSHConfig{}, nil)
+	return e
 }
 
-func (e *Executor) ExecuteCommand(command string, hostName string) (string, error) {
-	// For now, assume all commands are local
-	e.logger.Infof("Receieved Cmd to execute : %s", command)
-	parts := strings.Fields(command)
-	if len(parts) == 0 {
-		return "", fmt.Errorf("invalid command: %s", command)
+// ConfigureHosts sets up the built-in runners from the SSH defaults and
+// per-host settings, and records which runner each host uses.
+func (e *Executor) ConfigureHosts(sshConfig models.SSHConfig, hosts []models.Host) {
+	if e.ssh != nil {
+		e.ssh.Close()
+	}
+	e.ssh = NewSSHRunner(sshConfig, hosts)
+	e.runners = map[string]Runner{
+		RunnerLocal:  LocalRunner{},
+		RunnerSSH:    e.ssh,
+		RunnerDocker: NewDockerRunner(hosts),
+	}
+
+	e.hostRunners = make(map[string]string)
+	for _, host := range hosts {
+		if host.Runner != "" {
+			e.hostRunners[host.Name] = host.Runner
+		}
 	}
-	cmd := exec.Command(parts[0], parts[1:]...)
+}
+
+// SetAudit makes every command the executor runs from now on go to log.
+func (e *Executor) SetAudit(log *audit.Log) {
+	e.audit = log
+}
+
+// RegisterRunner adds or replaces the runner with the given name.
+func (e *Executor) RegisterRunner(name string, runner Runner) {
+	e.runners[name] = runner
+}
+
+// Close releases any open SSH connections.
+func (e *Executor) Close() {
+	e.ssh.Close()
+}
 
-	output, err := cmd.CombinedOutput()
+// runnerName picks the runner for a command: the process setting wins, then
+// the host setting, then local for localhost and ssh for anything else.
+func (e *Executor) runnerName(processRunner, hostName string) string {
+	if processRunner != "" {
+		return processRunner
+	}
+	if hostRunner := e.hostRunners[hostName]; hostRunner != "" {
+		return hostRunner
+	}
+	if isLocalHost(hostName) {
+		return RunnerLocal
+	}
+	return RunnerSSH
+}
+
+func (e *Executor) runnerFor(processRunner, hostName string) (Runner, error) {
+	runnerName := e.runnerName(processRunner, hostName)
+	runner, ok := e.runners[runnerName]
+	if !ok {
+		return nil, fmt.Errorf("unknown runner '%s' for host '%s'", runnerName, hostName)
+	}
+	return runner, nil
+}
+
+// Process actions, selecting which of a process's commands to run.
+const (
+	ActionStart  = "start"
+	ActionStop   = "stop"
+	ActionStatus = "status"
+	ActionKill   = "kill"
+	// ActionLock labels the commands that manage the run lock.
+	ActionLock = "lock"
+)
+
+func (e *Executor) ExecuteCommand(ctx context.Context, command string, hostName string) (string, error) {
+	cmd, err := ParseCommand(command, false)
 	if err != nil {
-		return "", fmt.Errorf("error executing command '%s' on host '%s': %w, output: %s", command, hostName, err, string(output))
+		return "", err
 	}
+	return commandOutput(e.execute(ctx, "", cmd, hostName, 0))
+}
 
-	return string(output), nil
+// ExecuteProcessCommand runs a command line on the process's host, honoring
+// the shell and runner settings of the process.
+func (e *Executor) ExecuteProcessCommand(ctx context.Context, process *models.Process, command string) (string, error) {
+	cmd, err := ParseCommand(command, process.Shell)
+	if err != nil {
+		return "", err
+	}
+	ctx = WithLabels(ctx, Labels{Process: process.Name, Host: process.HostName})
+	return commandOutput(e.execute(ctx, process.Runner, cmd, process.HostName, process.Timeout))
 }
 
-func (e *Executor) StartService(service *models.Service) error {
-	e.logger.Infof("Starting service: %s", service.Name)
+// RunProcessAction runs the process's start, stop or status command, using
+// the argv list for the action when one is configured.
+func (e *Executor) RunProcessAction(ctx context.Context, process *models.Process, action string) (string, error) {
+	return commandOutput(e.runProcessAction(ctx, process, action))
+}
 
-	for _, process := range service.Processes {
-		e.logger.Infof("Starting process: %s on host: %s", process.Name, process.HostName)
-		e.logger.Infof("StartCmd: %s", process.StartCmd)
-		_, err := e.ExecuteCommand(process.StartCmd, process.HostName)
-		if err != nil {
-			return err
-		}
+// RunProcessActionResult is RunProcessAction returning the whole Result, for
+// callers interested in how many attempts the command took.
+func (e *Executor) RunProcessActionResult(ctx context.Context, process *models.Process, action string) (Result, error) {
+	result, err := e.runProcessAction(ctx, process, action)
+	if err == nil {
+		_, err = commandOutput(result, nil)
+	}
+	return result, err
+}
 
-		// Wait for the process to start
-		time.Sleep(time.Duration(e.waitTime) * time.Second)
+// runProcessAction runs the command for action, retrying it as the process's
+// retry policy allows.
+func (e *Executor) runProcessAction(ctx context.Context, process *models.Process, action string) (Result, error) {
+	cmd, err := processCommand(process, action)
+	if err != nil {
+		return Result{}, err
+	}
+	ctx = WithLabels(ctx, Labels{Process: process.Name, Action: action, Host: process.HostName})
 
-		// Check if the process is running
-		isRunning, err := e.CheckProcess(&process)
-		if err != nil {
-			return err
+	attempts := maxAttempts(process.Retry)
+	for attempt := 1; ; attempt++ {
+		result, err := e.execute(WithLabels(ctx, Labels{Attempt: attempt}), process.Runner, cmd, process.HostName, process.Timeout)
+		result.Attempts = attempt
+		if attempt == attempts || !retryable(process.Retry, action, result, err) {
+			if err != nil && attempt > 1 {
+				err = fmt.Errorf("%w (after %d attempts)", err, attempt)
+			}
+			return result, err
 		}
-		if !isRunning {
-			return fmt.Errorf("process %s on host %s failed to start", process.Name, process.HostName)
+
+		delay := retryDelay(process.Retry, attempt)
+		e.log(ctx).Warnf("Attempt %d/%d of %s for process %s on host %s failed, retrying in %s", attempt, attempts, action, process.Name, process.HostName, delay)
+		if err := Sleep(ctx, delay); err != nil {
+			return result, err
 		}
 	}
+}
 
-	e.logger.Infof("Service %s started successfully.", service.Name)
-	return nil
+// PlanProcessAction renders the command RunProcessAction would run, and
+// where, without running it.
+func (e *Executor) PlanProcessAction(process *models.Process, action string) (models.PlannedStep, error) {
+	cmd, err := processCommand(process, action)
+	if err != nil {
+		return models.PlannedStep{}, err
+	}
+	return e.plannedStep(process, cmd)
 }
 
-func (e *Executor) StopService(service *models.Service) error {
-	e.logger.Infof("Stopping service: %s", service.Name)
+// PlanProcessCommand renders the command ExecuteProcessCommand would run, and
+// where, without running it.
+func (e *Executor) PlanProcessCommand(process *models.Process, command string) (models.PlannedStep, error) {
+	cmd, err := ParseCommand(command, process.Shell)
+	if err != nil {
+		return models.PlannedStep{}, err
+	}
+	return e.plannedStep(process, cmd)
+}
 
-	for _, process := range service.Processes {
-		e.logger.Infof("Stopping process: %s on host: %s", process.Name, process.HostName)
-		_, err := e.ExecuteCommand(process.StopCmd, process.HostName)
-		if err != nil {
-			return err
-		}
+// plannedStep renders cmd as run for process.
+func (e *Executor) plannedStep(process *models.Process, cmd Command) (models.PlannedStep, error) {
+	if _, err := e.runnerFor(process.Runner, process.HostName); err != nil {
+		return models.PlannedStep{}, err
+	}
+	return models.PlannedStep{
+		Process: process.Name,
+		Host:    process.HostName,
+		Runner:  e.runnerName(process.Runner, process.HostName),
+		Command: cmd.String(),
+	}, nil
+}
 
-		// Wait for the service to stop
-		time.Sleep(time.Duration(e.waitTime) * time.Second)
+// processCommand builds the command for one of the process's actions.
+func processCommand(process *models.Process, action string) (Command, error) {
+	var line string
+	var argv []string
+	switch action {
+	case ActionStart:
+		line, argv = process.StartCmd, process.Argv.Start
+	case ActionStop:
+		line, argv = process.StopCmd, process.Argv.Stop
+	case ActionStatus:
+		line, argv = process.StatusCmd, process.Argv.Status
+	case ActionKill:
+		line, argv = process.KillCmd, process.Argv.Kill
+	default:
+		return Command{}, fmt.Errorf("unknown action '%s' for process %s", action, process.Name)
+	}
 
-		// Check if the process is stopped
-		isRunning, err := e.CheckProcess(&process)
-		if err != nil {
-			return err
-		}
-		if isRunning {
-			return fmt.Errorf("process %s on host %s failed to stop", process.Name, process.HostName)
+	if len(argv) > 0 {
+		return ArgvCommand(argv), nil
+	}
+	return ParseCommand(line, process.Shell)
+}
+
+// execute runs command through the selected runner. A positive timeout, in
+// seconds, bounds the command in addition to any deadline on ctx. A non-zero
+// exit code is not an error here.
+func (e *Executor) execute(ctx context.Context, runnerName string, command Command, hostName string, timeout int) (Result, error) {
+	ctx = WithLabels(ctx, Labels{Host: hostName})
+	e.log(ctx).Debugf("Receieved Cmd to execute : %s on host: %s", command, hostName)
+
+	runner, err := e.runnerFor(runnerName, hostName)
+	if err != nil {
+		return Result{}, err
+	}
+
+	runCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+		defer cancel()
+	}
+
+	started := time.Now()
+	out := e.openOutput(ctx, hostName)
+	result, err := runner.Run(out.context(runCtx), hostName, command)
+	out.close()
+	defer func() {
+		e.record(ctx, e.runnerName(runnerName, hostName), hostName, command, started, out.path, result, err)
+	}()
+	if err != nil {
+		if errors.Is(runCtx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("command '%s' on host '%s' %w", command, hostName, ErrTimedOut)
+			return result, err
 		}
+		err = fmt.Errorf("error executing command '%s' on host '%s': %w, output: %s", command, hostName, err, result.Output())
+		return result, err
 	}
 
-	e.logger.Infof("Service %s stopped successfully.", service.Name)
-	return nil
+	return result, nil
 }
 
-func (e *Executor) CheckService(service *models.Service) []models.CheckResult {
+// commandOutput treats a non-zero exit code as a failure and returns the
+// combined output otherwise.
+func commandOutput(result Result, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	if result.ExitCode != 0 && result.Attempts > 1 {
+		return "", fmt.Errorf("command exited with code %d after %d attempts, output: %s", result.ExitCode, result.Attempts, result.Output())
+	}
+	if result.ExitCode != 0 {
+		return "", fmt.Errorf("command exited with code %d, output: %s", result.ExitCode, result.Output())
+	}
+	return result.Output(), nil
+}
+
+func (e *Executor) CheckService(ctx context.Context, service *models.Service) []models.CheckResult {
+	ctx = WithLabels(ctx, Labels{Service: service.Name})
 	var results []models.CheckResult
 
 	for _, process := range service.Processes {
-		isRunning, err := e.CheckProcess(&process)
+		state, err := e.CheckProcess(ctx, &process)
 		if err != nil {
-			e.logger.Errorf("Error checking process %s on host %s: %v", process.Name, process.HostName, err)
-			isRunning = false // Assume not running in case of error
+			e.log(WithLabels(ctx, Labels{Process: process.Name, Host: process.HostName})).Errorf("Error checking process %s on host %s: %v", process.Name, process.HostName, err)
 		}
 
 		results = append(results, models.CheckResult{
 			ServiceName: service.Name,
 			ProcessName: process.Name,
 			HostName:    process.HostName,
-			IsRunning:   isRunning,
+			State:       state,
+			IsRunning:   IsUp(state),
 		})
 	}
 
 	return results
 }
 
-func (e *Executor) CheckProcess(process *models.Process) (bool, error) {
-	output, err := e.ExecuteCommand(process.StatusCmd, process.HostName)
+// CheckProcess evaluates the process's health probes when it has any, and
+// otherwise runs the status command and evaluates it with the process's
+// status policy. The state is unknown or timed_out whenever an error is
+// returned.
+func (e *Executor) CheckProcess(ctx context.Context, process *models.Process) (models.ProcessState, error) {
+	if process.Health != nil {
+		return e.probeHealth(ctx, process)
+	}
+
+	result, err := e.runProcessAction(ctx, process, ActionStatus)
+	if errors.Is(err, ErrTimedOut) {
+		return models.StateTimedOut, err
+	}
 	if err != nil {
-		return false, err
+		return models.StateUnknown, err
+	}
+	return EvaluateStatus(process.Status, result)
+}
+
+// WaitForState polls the process until it is in state want, returning as
+// soon as it is; a degraded process counts as running, like for IsUp. Polling starts after the process's initial_delay, repeats
+// every poll_interval and gives up after ready_timeout seconds; without a
+// ready_timeout the process is checked just once.
+func (e *Executor) WaitForState(ctx context.Context, process *models.Process, want models.ProcessState) error {
+	return e.WaitForStateWithin(ctx, process, want, process.ReadyTimeout)
+}
+
+// WaitForStateWithin is WaitForState with timeout, in seconds, instead of the
+// process's ready_timeout.
+func (e *Executor) WaitForStateWithin(ctx context.Context, process *models.Process, want models.ProcessState, timeout int) error {
+	if err := Sleep(ctx, time.Duration(process.InitialDelay)*time.Second); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(time.Duration(timeout) * time.Second)
+	interval := time.Duration(max(process.PollInterval, 1)) * time.Second
+	for {
+		state, err := e.CheckProcess(ctx, process)
+		if err == nil && (state == want || want == models.StateRunning && IsUp(state)) {
+			return nil
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("process %s on host %s not %s after %ds (%s)", process.Name, process.HostName, want, timeout, state)
+		}
+		e.log(WithLabels(ctx, Labels{Process: process.Name, Host: process.HostName})).Infof("Process %s on host %s is %s, waiting for %s", process.Name, process.HostName, state, want)
+		if err := Sleep(ctx, min(interval, remaining)); err != nil {
+			return err
+		}
+	}
+}
+
+// IsUp reports whether a process in the given state is running, even if
+// degraded.
+func IsUp(state models.ProcessState) bool {
+	return state == models.StateRunning || state == models.StateDegraded
+}
+
+// Sleep waits for d, returning early with the context's error if ctx is done.
+func Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
 	}
-	// Consider running if we get non-empty stdout
-	return output != "", nil
 }
@@ -0,0 +1,93 @@
+package executor
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// FakeResponse is a scripted result returned by FakeRunner after Delay.
+// Output is returned as stdout.
+type FakeResponse struct {
+	Output   string
+	Stderr   string
+	ExitCode int
+	Err      error
+	Delay    time.Duration
+}
+
+// FakeCall records a command received by FakeRunner.
+type FakeCall struct {
+	HostName string
+	Command  string
+}
+
+// FakeRunner is an in-memory Runner for tests. Responses are scripted per
+// host and command line (as rendered by Command.String) and consumed in
+// order; the last one keeps repeating, so a status command can be scripted to
+// flip from stopped to running. Commands with no script succeed with empty
+// output.
+type FakeRunner struct {
+	mu        sync.Mutex
+	responses map[string][]FakeResponse
+	calls     []FakeCall
+}
+
+func NewFakeRunner() *FakeRunner {
+	return &FakeRunner{
+		responses: make(map[string][]FakeResponse),
+	}
+}
+
+// On queues responses for command on hostName.
+func (f *FakeRunner) On(hostName, command string, responses ...FakeResponse) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := fakeKey(hostName, command)
+	f.responses[key] = append(f.responses[key], responses...)
+}
+
+// Calls returns every command run so far, in order.
+func (f *FakeRunner) Calls() []FakeCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]FakeCall(nil), f.calls...)
+}
+
+func (f *FakeRunner) Run(ctx context.Context, hostName string, command Command) (Result, error) {
+	response := f.next(hostName, command.String())
+	if response.Delay > 0 {
+		if err := Sleep(ctx, response.Delay); err != nil {
+			return Result{}, err
+		}
+	}
+	stdout, stderr := OutputWriters(ctx)
+	io.WriteString(stdout, response.Output)
+	io.WriteString(stderr, response.Stderr)
+	return Result{Stdout: response.Output, Stderr: response.Stderr, ExitCode: response.ExitCode}, response.Err
+}
+
+func (f *FakeRunner) next(hostName, line string) FakeResponse {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls = append(f.calls, FakeCall{HostName: hostName, Command: line})
+
+	key := fakeKey(hostName, line)
+	queue := f.responses[key]
+	if len(queue) == 0 {
+		return FakeResponse{}
+	}
+	response := queue[0]
+	if len(queue) > 1 {
+		f.responses[key] = queue[1:]
+	}
+	return response
+}
+
+func fakeKey(hostName, command string) string {
+	return hostName + "\x00" + command
+}
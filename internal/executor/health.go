@@ -0,0 +1,187 @@
+package executor
+
+import (
+	"big-brother/internal/models"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultProbeTimeout = 5 * time.Second
+
+// maxProbeBody limits how much of an HTTP response body is matched.
+const maxProbeBody = 1 << 20
+
+// probeHealth evaluates the health probes of a process. HTTP and TCP probes
+// always run from this machine; pidfile and process_name probes are checked
+// natively for local processes and through the process's runner otherwise.
+func (e *Executor) probeHealth(ctx context.Context, process *models.Process) (models.ProcessState, error) {
+	timeout := defaultProbeTimeout
+	if process.Timeout > 0 {
+		timeout = time.Duration(process.Timeout) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	health := process.Health
+	local := e.runnerName(process.Runner, process.HostName) == RunnerLocal
+
+	var probes []func() (bool, error)
+	if health.HTTP != nil {
+		probes = append(probes, func() (bool, error) { return probeHTTP(ctx, health.HTTP) })
+	}
+	if health.TCP != "" {
+		probes = append(probes, func() (bool, error) { return probeTCP(ctx, health.TCP) })
+	}
+	if health.PIDFile != "" {
+		probes = append(probes, func() (bool, error) {
+			if local {
+				return probePIDFile(health.PIDFile)
+			}
+			line := fmt.Sprintf(`kill -0 "$(cat %s)"`, QuoteWord(health.PIDFile))
+			return e.probeCommand(ctx, process, Command{Shell: true, Line: line})
+		})
+	}
+	if health.ProcessName != "" {
+		probes = append(probes, func() (bool, error) {
+			if local {
+				if found, err := probeProcessName(health.ProcessName); !errors.Is(err, errNoProcfs) {
+					return found, err
+				}
+			}
+			return e.probeCommand(ctx, process, ArgvCommand([]string{"pgrep", "-f", health.ProcessName}))
+		})
+	}
+
+	for _, probe := range probes {
+		ok, err := probe()
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return models.StateTimedOut, fmt.Errorf("health check of process %s %w", process.Name, ErrTimedOut)
+		}
+		if err != nil {
+			return models.StateUnknown, err
+		}
+		if !ok {
+			return models.StateStopped, nil
+		}
+	}
+	return models.StateRunning, nil
+}
+
+// probeCommand runs a check command on the process's host; exit code 0 means
+// the check passed.
+func (e *Executor) probeCommand(ctx context.Context, process *models.Process, command Command) (bool, error) {
+	ctx = WithLabels(ctx, Labels{Process: process.Name, Action: ActionStatus})
+	result, err := e.execute(ctx, process.Runner, command, process.HostName, 0)
+	if err != nil {
+		return false, err
+	}
+	return result.ExitCode == 0, nil
+}
+
+func probeHTTP(ctx context.Context, check *models.HTTPCheck) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, check.URL, nil)
+	if err != nil {
+		return false, fmt.Errorf("invalid health check url: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		// Nothing listening, or not answering in time
+		return false, nil
+	}
+	defer resp.Body.Close()
+
+	expectStatus := check.ExpectStatus
+	if expectStatus == 0 {
+		expectStatus = http.StatusOK
+	}
+	if resp.StatusCode != expectStatus {
+		return false, nil
+	}
+
+	if check.BodyRegex != "" {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxProbeBody))
+		if err != nil {
+			return false, nil
+		}
+		return regexp.Match(check.BodyRegex, body)
+	}
+	return true, nil
+}
+
+func probeTCP(ctx context.Context, address string) (bool, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return false, nil
+	}
+	conn.Close()
+	return true, nil
+}
+
+func probePIDFile(path string) (bool, error) {
+	pid, err := readPIDFile(path)
+	if err != nil || pid == 0 {
+		return false, err
+	}
+	return processExists(pid), nil
+}
+
+// readPIDFile returns the pid stored in path, or 0 if there is no such file.
+func readPIDFile(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error reading pid file: %w", err)
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("invalid pid in pid file %s", path)
+	}
+	return pid, nil
+}
+
+var errNoProcfs = errors.New("procfs not available")
+
+// probeProcessName looks for a running process whose command line matches
+// pattern by scanning /proc.
+func probeProcessName(pattern string) (bool, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return false, err
+	}
+
+	cmdlines, err := filepath.Glob("/proc/[0-9]*/cmdline")
+	if err != nil || len(cmdlines) == 0 {
+		return false, errNoProcfs
+	}
+
+	self := strconv.Itoa(os.Getpid())
+	for _, path := range cmdlines {
+		if filepath.Base(filepath.Dir(path)) == self {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil || len(data) == 0 {
+			continue
+		}
+		cmdline := strings.TrimSpace(strings.ReplaceAll(string(data), "\x00", " "))
+		if re.MatchString(cmdline) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
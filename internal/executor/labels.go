@@ -0,0 +1,124 @@
+package executor
+
+import (
+	"big-brother/internal/audit"
+	"big-brother/internal/logger"
+	"big-brother/internal/models"
+	"context"
+	"time"
+)
+
+// Labels say what the commands run under a context are for. The App sets the
+// run and service, the executor adds the process, action, host and, for
+// retried actions, the attempt number.
+type Labels struct {
+	RunID   string
+	Service string
+	Process string
+	Action  string
+	Host    string
+	Attempt int
+}
+
+type labelsKey struct{}
+
+type recorderKey struct{}
+
+// WithLabels returns a context carrying the labels of ctx with the non-empty
+// fields of labels replacing them.
+func WithLabels(ctx context.Context, labels Labels) context.Context {
+	merged := LabelsFrom(ctx)
+	if labels.RunID != "" {
+		merged.RunID = labels.RunID
+	}
+	if labels.Service != "" {
+		merged.Service = labels.Service
+	}
+	if labels.Process != "" {
+		merged.Process = labels.Process
+	}
+	if labels.Action != "" {
+		merged.Action = labels.Action
+	}
+	if labels.Host != "" {
+		merged.Host = labels.Host
+	}
+	if labels.Attempt != 0 {
+		merged.Attempt = labels.Attempt
+	}
+	return context.WithValue(ctx, labelsKey{}, merged)
+}
+
+// LabelsFrom returns the labels attached to ctx.
+func LabelsFrom(ctx context.Context) Labels {
+	labels, _ := ctx.Value(labelsKey{}).(Labels)
+	return labels
+}
+
+// ContextLogger returns log with the labels of ctx attached as fields.
+func ContextLogger(ctx context.Context, log *logger.Logger) *logger.Logger {
+	labels := LabelsFrom(ctx)
+	return log.With("run", labels.RunID).
+		With("service", labels.Service).
+		With("process", labels.Process).
+		With("host", labels.Host)
+}
+
+// log returns the executor's logger with the labels of ctx attached.
+func (e *Executor) log(ctx context.Context) *logger.Logger {
+	return ContextLogger(ctx, e.logger)
+}
+
+// WithRecorder returns a context under which every command the executor runs
+// is passed to record once it has finished. record may be called from several
+// goroutines at once.
+func WithRecorder(ctx context.Context, record func(models.CommandRecord)) context.Context {
+	return context.WithValue(ctx, recorderKey{}, record)
+}
+
+// record passes a finished command to the recorder of ctx, if there is one,
+// and to the audit log.
+func (e *Executor) record(ctx context.Context, runnerName, hostName string, command Command, started time.Time, outputFile string, result Result, err error) {
+	labels := LabelsFrom(ctx)
+	errText := ""
+	if err != nil {
+		errText = err.Error()
+	}
+
+	if recordFunc, ok := ctx.Value(recorderKey{}).(func(models.CommandRecord)); ok {
+		recordFunc(models.CommandRecord{
+			Service:    labels.Service,
+			Process:    labels.Process,
+			Action:     labels.Action,
+			Host:       hostName,
+			Runner:     runnerName,
+			Command:    command.String(),
+			Started:    started,
+			Duration:   time.Since(started),
+			ExitCode:   result.ExitCode,
+			Error:      errText,
+			Attempt:    labels.Attempt,
+			OutputFile: outputFile,
+		})
+	}
+
+	if e.audit != nil && e.audit.Wants(labels.Action) {
+		auditErr := e.audit.Record(audit.Entry{
+			Time:       started,
+			RunID:      labels.RunID,
+			Service:    labels.Service,
+			Process:    labels.Process,
+			Action:     labels.Action,
+			Host:       hostName,
+			Runner:     runnerName,
+			Command:    command.String(),
+			ExitCode:   result.ExitCode,
+			DurationMS: time.Since(started).Milliseconds(),
+			Output:     result.Output(),
+			Error:      errText,
+		})
+		if auditErr != nil {
+			e.log(ctx).Errorf("Error writing audit log: %v", auditErr)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Stream prints the output of commands while they run, each line prefixed
+// with [service/process@host]. Lines are written whole, so the output of
+// commands running in parallel interleaves line by line.
+type Stream struct {
+	mu     sync.Mutex
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// NewStream returns a Stream printing the stdout of commands to stdout and
+// their stderr to stderr.
+func NewStream(stdout, stderr io.Writer) *Stream {
+	return &Stream{stdout: stdout, stderr: stderr}
+}
+
+func (s *Stream) writeLine(w io.Writer, line []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Write(line)
+}
+
+// SetStream makes the executor print the output of the commands it runs for
+// processes from now on, status checks and lock commands excepted.
+func (e *Executor) SetStream(stream *Stream) {
+	e.stream = stream
+}
+
+type outputKey struct{}
+
+type outputDirKey struct{}
+
+type outputWriters struct {
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// outputDir numbers the output files of a run in the order the commands
+// started.
+type outputDir struct {
+	path string
+	seq  atomic.Int64
+}
+
+// WithOutputDir returns a context under which the output of every command run
+// for a process, status checks and lock commands excepted, is saved to its
+// own file in dir.
+// The directory is created with the first file.
+func WithOutputDir(ctx context.Context, dir string) context.Context {
+	return context.WithValue(ctx, outputDirKey{}, &outputDir{path: dir})
+}
+
+// OutputWriters returns the writers a runner copies the stdout and stderr of
+// the command it runs under ctx to, as the output arrives. Both discard
+// everything when nobody is interested.
+func OutputWriters(ctx context.Context) (stdout, stderr io.Writer) {
+	writers, ok := ctx.Value(outputKey{}).(outputWriters)
+	if !ok {
+		return io.Discard, io.Discard
+	}
+	return writers.stdout, writers.stderr
+}
+
+// output is where the output of one command goes besides its Result.
+type output struct {
+	stdout  io.Writer
+	stderr  io.Writer
+	file    *os.File
+	path    string
+	streams []*prefixWriter
+}
+
+// openOutput sets up streaming and saving the output of command according to
+// the executor's stream and the output directory of ctx. Failing to create
+// the output file is logged, not fatal.
+func (e *Executor) openOutput(ctx context.Context, hostName string) *output {
+	out := &output{}
+	labels := LabelsFrom(ctx)
+	if labels.Process == "" || labels.Action == ActionStatus || labels.Action == ActionLock {
+		return out
+	}
+
+	var stdouts, stderrs []io.Writer
+	if dir, ok := ctx.Value(outputDirKey{}).(*outputDir); ok {
+		if err := out.create(dir, labels); err != nil {
+			e.log(ctx).Warnf("Not saving the output of the command: %v", err)
+		} else {
+			file := &syncWriter{w: out.file}
+			stdouts, stderrs = append(stdouts, file), append(stderrs, file)
+		}
+	}
+	if e.stream != nil {
+		prefix := fmt.Sprintf("[%s@%s] ", strings.TrimPrefix(labels.Service+"/"+labels.Process, "/"), hostName)
+		stdout := &prefixWriter{stream: e.stream, w: e.stream.stdout, prefix: prefix}
+		stderr := &prefixWriter{stream: e.stream, w: e.stream.stderr, prefix: prefix}
+		out.streams = []*prefixWriter{stdout, stderr}
+		stdouts, stderrs = append(stdouts, stdout), append(stderrs, stderr)
+	}
+
+	if len(stdouts) > 0 {
+		out.stdout, out.stderr = io.MultiWriter(stdouts...), io.MultiWriter(stderrs...)
+	}
+	return out
+}
+
+// create opens the next output file of dir, named after the command's
+// labels.
+func (o *output) create(dir *outputDir, labels Labels) error {
+	if err := os.MkdirAll(dir.path, 0o755); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%03d", dir.seq.Add(1))
+	for _, part := range []string{labels.Service, labels.Process, labels.Action} {
+		if part != "" {
+			name += "-" + strings.Map(fileNameRune, part)
+		}
+	}
+	path := filepath.Join(dir.path, name+".log")
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	o.file, o.path = file, path
+	return nil
+}
+
+// context returns ctx carrying the writers of o for the runner.
+func (o *output) context(ctx context.Context) context.Context {
+	if o.stdout == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, outputKey{}, outputWriters{stdout: o.stdout, stderr: o.stderr})
+}
+
+// close prints what is left of unterminated lines and closes the output
+// file.
+func (o *output) close() {
+	for _, stream := range o.streams {
+		stream.flush()
+	}
+	if o.file != nil {
+		o.file.Close()
+	}
+}
+
+// fileNameRune keeps file names made of labels free of path separators.
+func fileNameRune(r rune) rune {
+	if r == '/' || r == '\\' || r == os.PathSeparator {
+		return '_'
+	}
+	return r
+}
+
+// prefixWriter passes whole lines to a Stream, each after prefix.
+type prefixWriter struct {
+	stream *Stream
+	w      io.Writer
+	prefix string
+	buf    []byte
+}
+
+func (p *prefixWriter) Write(data []byte) (int, error) {
+	p.buf = append(p.buf, data...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			break
+		}
+		p.stream.writeLine(p.w, append([]byte(p.prefix), p.buf[:i+1]...))
+		p.buf = p.buf[i+1:]
+	}
+	return len(data), nil
+}
+
+func (p *prefixWriter) flush() {
+	if len(p.buf) > 0 {
+		p.stream.writeLine(p.w, append([]byte(p.prefix), append(p.buf, '\n')...))
+		p.buf = nil
+	}
+}
+
+// syncWriter lets the stdout and stderr of a command share one file.
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(data []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(data)
+}
@@ -0,0 +1,22 @@
+//go:build windows
+
+package executor
+
+import (
+	"os"
+	"os/exec"
+)
+
+// killProcessGroupOnCancel keeps the default behavior of killing only the
+// command itself, as Windows has no process groups to signal.
+func killProcessGroupOnCancel(cmd *exec.Cmd) {}
+
+// processExists reports whether a process with the given pid is alive.
+func processExists(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	process.Release()
+	return true
+}
@@ -0,0 +1,50 @@
+package executor
+
+import (
+	"big-brother/internal/models"
+	"errors"
+	"math/rand/v2"
+	"slices"
+	"time"
+)
+
+// maxAttempts returns how often a command may run under policy.
+func maxAttempts(policy *models.RetryPolicy) int {
+	if policy == nil {
+		return 1
+	}
+	return max(policy.Attempts, 1)
+}
+
+// retryable reports whether a failed run of the command for action may be
+// retried under policy. Start and stop commands are retried for the listed
+// exit codes, or any non-zero one when none are listed; status commands
+// report state through their exit code and are only retried on timeouts.
+func retryable(policy *models.RetryPolicy, action string, result Result, err error) bool {
+	if policy == nil {
+		return false
+	}
+	if err != nil {
+		return policy.OnTimeout && errors.Is(err, ErrTimedOut)
+	}
+	if result.ExitCode == 0 || action == ActionStatus {
+		return false
+	}
+	return len(policy.ExitCodes) == 0 || slices.Contains(policy.ExitCodes, result.ExitCode)
+}
+
+// retryDelay returns how long to wait after the given failed attempt,
+// counting from 1.
+func retryDelay(policy *models.RetryPolicy, attempt int) time.Duration {
+	delay := time.Duration(policy.Delay) * time.Second
+	if policy.Backoff == models.BackoffExponential {
+		delay <<= min(attempt-1, 20)
+	}
+	if policy.MaxDelay > 0 {
+		delay = min(delay, time.Duration(policy.MaxDelay)*time.Second)
+	}
+	if policy.Jitter > 0 {
+		delay += time.Duration(float64(delay) * policy.Jitter * (2*rand.Float64() - 1))
+	}
+	return delay
+}
@@ -0,0 +1,81 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// Names of the built-in runners, as used by the runner field of hosts and
+// processes in the config.
+const (
+	RunnerLocal  = "local"
+	RunnerSSH    = "ssh"
+	RunnerDocker = "docker"
+)
+
+// commandWaitDelay bounds how long a killed command may keep its output
+// pipes open through leftover children.
+const commandWaitDelay = 2 * time.Second
+
+// Result is the outcome of a command that ran to completion. Attempts counts
+// the runs it took when the command was retried.
+type Result struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	Attempts int
+}
+
+// Output returns stdout followed by stderr.
+func (r Result) Output() string {
+	return r.Stdout + r.Stderr
+}
+
+// Runner is a transport that executes a command on a host. A non-zero exit
+// is reported through Result.ExitCode; an error means the command could not
+// be run or was stopped. Runners must stop the command when ctx is done, and
+// should copy the output to the writers of OutputWriters(ctx) as it arrives.
+type Runner interface {
+	Run(ctx context.Context, hostName string, command Command) (Result, error)
+}
+
+// LocalRunner executes commands on the machine big-brother runs on.
+type LocalRunner struct{}
+
+func (LocalRunner) Run(ctx context.Context, hostName string, command Command) (Result, error) {
+	argv := command.argv()
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	if len(command.Env) > 0 {
+		cmd.Env = append(os.Environ(), command.Env...)
+	}
+	return runCmd(ctx, cmd)
+}
+
+// runCmd runs a local command, killing its process group when ctx is done.
+func runCmd(ctx context.Context, cmd *exec.Cmd) (Result, error) {
+	killProcessGroupOnCancel(cmd)
+	cmd.WaitDelay = commandWaitDelay
+
+	var stdout, stderr bytes.Buffer
+	streamOut, streamErr := OutputWriters(ctx)
+	cmd.Stdout = io.MultiWriter(&stdout, streamOut)
+	cmd.Stderr = io.MultiWriter(&stderr, streamErr)
+
+	err := cmd.Run()
+	result := Result{Stdout: stdout.String(), Stderr: stderr.String()}
+	if ctx.Err() != nil {
+		return result, ctx.Err()
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		result.ExitCode = exitErr.ExitCode()
+		return result, nil
+	}
+	return result, err
+}
@@ -0,0 +1,43 @@
+package executor
+
+import (
+	"big-brother/internal/models"
+	"errors"
+	"os"
+)
+
+// HasKillCommand reports whether the process has a kill_cmd or argv.kill.
+func HasKillCommand(process *models.Process) bool {
+	return process.KillCmd != "" || len(process.Argv.Kill) > 0
+}
+
+// SignalProcess sends sig to a process run by the local runner whose pid is
+// known from its pidfile health probe. It reports false, without an error,
+// when there is no such pid to signal.
+func (e *Executor) SignalProcess(process *models.Process, sig os.Signal) (bool, error) {
+	if process.Health == nil || process.Health.PIDFile == "" || e.runnerName(process.Runner, process.HostName) != RunnerLocal {
+		return false, nil
+	}
+
+	pid, err := readPIDFile(process.Health.PIDFile)
+	if err != nil || pid == 0 {
+		return false, err
+	}
+	osProcess, err := os.FindProcess(pid)
+	if err != nil {
+		return false, err
+	}
+	defer osProcess.Release()
+
+	e.logger.With("process", process.Name).With("host", process.HostName).Infof("Sending %s to pid %d of process %s", sig, pid, process.Name)
+	if err := osProcess.Signal(sig); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return true, err
+	}
+	return true, nil
+}
+
+// LocalProcessExists reports whether a process with the given pid is alive on
+// this machine.
+func LocalProcessExists(pid int) bool {
+	return processExists(pid)
+}
@@ -0,0 +1,327 @@
+package executor
+
+import (
+	"big-brother/internal/models"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	defaultSSHPort    = 22
+	sshDialTimeout    = 10 * time.Second
+	sshCloseTimeout   = 5 * time.Second
+	defaultKnownHosts = "~/.ssh/known_hosts"
+)
+
+var defaultKeyFiles = []string{"~/.ssh/id_ed25519", "~/.ssh/id_ecdsa", "~/.ssh/id_rsa"}
+
+// SSHRunner executes commands on remote hosts over SSH, keeping one
+// connection per host and one to the ssh agent.
+type SSHRunner struct {
+	config models.SSHConfig
+	hosts  map[string]models.Host
+
+	mu        sync.Mutex
+	conns     map[string]*sshConn
+	agentConn net.Conn
+	agent     agent.ExtendedAgent
+}
+
+// sshConn is the connection to a host, once ready is closed. Commands for the
+// host wait for the one dial in flight instead of dialing again.
+type sshConn struct {
+	ready  chan struct{}
+	client *ssh.Client
+	err    error
+}
+
+func NewSSHRunner(config models.SSHConfig, hosts []models.Host) *SSHRunner {
+	hostMap := make(map[string]models.Host)
+	for _, host := range hosts {
+		hostMap[host.Name] = host
+	}
+	return &SSHRunner{
+		config: config,
+		hosts:  hostMap,
+		conns:  make(map[string]*sshConn),
+	}
+}
+
+func (c *SSHRunner) Run(ctx context.Context, hostName string, command Command) (Result, error) {
+	client, session, err := c.newSession(hostName)
+	if err != nil {
+		return Result{}, err
+	}
+	defer session.Close()
+
+	var stdout, stderr bytes.Buffer
+	streamOut, streamErr := OutputWriters(ctx)
+	session.Stdout = io.MultiWriter(&stdout, streamOut)
+	session.Stderr = io.MultiWriter(&stderr, streamErr)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Run(command.String())
+	}()
+
+	select {
+	case err := <-done:
+		result := Result{Stdout: stdout.String(), Stderr: stderr.String()}
+		var exitErr *ssh.ExitError
+		if errors.As(err, &exitErr) {
+			result.ExitCode = exitErr.ExitStatus()
+			return result, nil
+		}
+		if err != nil {
+			// The connection may be gone; the next command dials again
+			c.drop(hostName, client)
+		}
+		return result, err
+	case <-ctx.Done():
+		// Ask the remote side to kill the command, then drop the session.
+		// Wait for the session to wind down, so that nothing is written to
+		// the output writers once this returns; a connection that does not
+		// answer any more is dropped to get there.
+		session.Signal(ssh.SIGKILL)
+		session.Close()
+		select {
+		case <-done:
+		case <-time.After(sshCloseTimeout):
+			c.drop(hostName, client)
+			<-done
+		}
+		return Result{}, ctx.Err()
+	}
+}
+
+// newSession opens a session on the connection to hostName. A connection
+// that can no longer open sessions, for example after sshd restarted, is
+// closed and dialed once more.
+func (c *SSHRunner) newSession(hostName string) (*ssh.Client, *ssh.Session, error) {
+	for attempt := 1; ; attempt++ {
+		client, err := c.connect(hostName)
+		if err != nil {
+			return nil, nil, err
+		}
+		session, err := client.NewSession()
+		if err == nil {
+			return client, session, nil
+		}
+		c.drop(hostName, client)
+		if attempt == 2 {
+			return nil, nil, fmt.Errorf("error opening ssh session on host '%s': %w", hostName, err)
+		}
+	}
+}
+
+// drop closes client and forgets it, unless it was replaced already.
+func (c *SSHRunner) drop(hostName string, client *ssh.Client) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if conn, ok := c.conns[hostName]; ok && conn.client == client {
+		delete(c.conns, hostName)
+	}
+	client.Close()
+}
+
+// connect returns the connection to hostName, dialing it when there is none.
+// The dial happens outside c.mu, so that a slow or unreachable host holds up
+// only the commands for that host.
+func (c *SSHRunner) connect(hostName string) (*ssh.Client, error) {
+	c.mu.Lock()
+	if conn, ok := c.conns[hostName]; ok {
+		c.mu.Unlock()
+		<-conn.ready
+		return conn.client, conn.err
+	}
+	conn := &sshConn{ready: make(chan struct{})}
+	c.conns[hostName] = conn
+	address, clientConfig, err := c.clientConfig(hostName)
+	c.mu.Unlock()
+
+	var client *ssh.Client
+	if err == nil {
+		client, err = ssh.Dial("tcp", address, clientConfig)
+		if err != nil {
+			err = fmt.Errorf("error connecting to host '%s' (%s): %w", hostName, address, err)
+		}
+	}
+
+	c.mu.Lock()
+	switch {
+	case c.conns[hostName] != conn:
+		// Closed while dialing
+		if client != nil {
+			client.Close()
+			client = nil
+		}
+		if err == nil {
+			err = fmt.Errorf("error connecting to host '%s': ssh runner closed", hostName)
+		}
+	case err != nil:
+		// The next command dials again
+		delete(c.conns, hostName)
+	}
+	conn.client, conn.err = client, err
+	c.mu.Unlock()
+	close(conn.ready)
+	return conn.client, conn.err
+}
+
+func (c *SSHRunner) clientConfig(hostName string) (string, *ssh.ClientConfig, error) {
+	host := c.hosts[hostName]
+
+	address := host.Address
+	if address == "" {
+		address = hostName
+	}
+	port := host.Port
+	if port == 0 {
+		port = c.config.Port
+	}
+	if port == 0 {
+		port = defaultSSHPort
+	}
+	userName := host.User
+	if userName == "" {
+		userName = c.config.User
+	}
+	if userName == "" {
+		current, err := user.Current()
+		if err != nil {
+			return "", nil, fmt.Errorf("error resolving ssh user for host '%s': %w", hostName, err)
+		}
+		userName = current.Username
+	}
+
+	knownHostsFile := c.config.KnownHosts
+	if knownHostsFile == "" {
+		knownHostsFile = defaultKnownHosts
+	}
+	hostKeyCallback, err := knownhosts.New(expandHome(knownHostsFile))
+	if err != nil {
+		return "", nil, fmt.Errorf("error loading known_hosts: %w", err)
+	}
+
+	keyFile := host.KeyFile
+	if keyFile == "" {
+		keyFile = c.config.KeyFile
+	}
+	auth, err := c.authMethods(keyFile)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return net.JoinHostPort(address, strconv.Itoa(port)), &ssh.ClientConfig{
+		User:            userName,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         sshDialTimeout,
+	}, nil
+}
+
+// authMethods prefers the ssh agent when available, then the configured key
+// file, falling back to the usual default keys in ~/.ssh. The agent
+// connection is opened once and shared by every host. Callers hold c.mu.
+func (c *SSHRunner) authMethods(keyFile string) ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+
+	if c.agent == nil {
+		if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+			if conn, err := net.Dial("unix", socket); err == nil {
+				c.agentConn, c.agent = conn, agent.NewClient(conn)
+			}
+		}
+	}
+	if c.agent != nil {
+		methods = append(methods, ssh.PublicKeysCallback(c.agent.Signers))
+	}
+
+	if keyFile != "" {
+		signer, err := loadSigner(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		methods = append(methods, ssh.PublicKeys(signer))
+	} else {
+		for _, path := range defaultKeyFiles {
+			if signer, err := loadSigner(path); err == nil {
+				methods = append(methods, ssh.PublicKeys(signer))
+			}
+		}
+	}
+
+	if len(methods) == 0 {
+		return nil, errors.New("no ssh authentication method available: configure key_file or start an ssh agent")
+	}
+	return methods, nil
+}
+
+func loadSigner(keyFile string) (ssh.Signer, error) {
+	data, err := os.ReadFile(expandHome(keyFile))
+	if err != nil {
+		return nil, fmt.Errorf("error reading ssh key file: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing ssh key file '%s': %w", keyFile, err)
+	}
+	return signer, nil
+}
+
+// Close closes every open connection, the one to the ssh agent included.
+func (c *SSHRunner) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for hostName, conn := range c.conns {
+		// A connection still being dialed is closed by its dialer
+		select {
+		case <-conn.ready:
+			if conn.client != nil {
+				conn.client.Close()
+			}
+		default:
+		}
+		delete(c.conns, hostName)
+	}
+	if c.agentConn != nil {
+		c.agentConn.Close()
+		c.agentConn, c.agent = nil, nil
+	}
+}
+
+func expandHome(path string) string {
+	if !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[2:])
+}
+
+func isLocalHost(hostName string) bool {
+	switch hostName {
+	case "", "localhost", "127.0.0.1", "::1":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package executor
+
+import (
+	"big-brother/internal/models"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// EvaluateStatus maps the result of a status command to a process state.
+// Without a policy the legacy rule applies: exit code 0 with some stdout is
+// running, anything else is stopped.
+func EvaluateStatus(policy *models.StatusPolicy, result Result) (models.ProcessState, error) {
+	if policy == nil {
+		if result.ExitCode == 0 && result.Stdout != "" {
+			return models.StateRunning, nil
+		}
+		return models.StateStopped, nil
+	}
+
+	runningCodes := policy.RunningExitCodes
+	if len(runningCodes) == 0 {
+		runningCodes = []int{0}
+	}
+	switch {
+	case slices.Contains(runningCodes, result.ExitCode):
+	case slices.Contains(policy.DegradedExitCodes, result.ExitCode):
+		return models.StateDegraded, nil
+	case slices.Contains(policy.StoppedExitCodes, result.ExitCode):
+		return models.StateStopped, nil
+	case len(policy.StoppedExitCodes) == 0 && len(policy.DegradedExitCodes) == 0:
+		return models.StateStopped, nil
+	default:
+		return models.StateUnknown, fmt.Errorf("unexpected status exit code %d", result.ExitCode)
+	}
+
+	if policy.DegradedMatch != "" {
+		matched, err := regexp.MatchString(policy.DegradedMatch, result.Stdout)
+		if err != nil {
+			return models.StateUnknown, err
+		}
+		if matched {
+			return models.StateDegraded, nil
+		}
+	}
+
+	predicates := []struct {
+		pattern string
+		output  string
+		want    bool
+	}{
+		{policy.StdoutMatch, result.Stdout, true},
+		{policy.StdoutNotMatch, result.Stdout, false},
+		{policy.StderrMatch, result.Stderr, true},
+		{policy.StderrNotMatch, result.Stderr, false},
+	}
+	for _, predicate := range predicates {
+		if predicate.pattern == "" {
+			continue
+		}
+		matched, err := regexp.MatchString(predicate.pattern, predicate.output)
+		if err != nil {
+			return models.StateUnknown, err
+		}
+		if matched != predicate.want {
+			return models.StateStopped, nil
+		}
+	}
+
+	if policy.JSONField != "" {
+		value, err := jsonField(result.Stdout, policy.JSONField)
+		if err != nil {
+			return models.StateUnknown, err
+		}
+		if len(policy.JSONEquals) > 0 && !slices.Contains(policy.JSONEquals, value) {
+			return models.StateStopped, nil
+		}
+	}
+
+	return models.StateRunning, nil
+}
+
+// jsonField looks up a dot separated path in a JSON document and returns the
+// value formatted as a string.
+func jsonField(document, path string) (string, error) {
+	var value interface{}
+	if err := json.Unmarshal([]byte(document), &value); err != nil {
+		return "", fmt.Errorf("error parsing status output as JSON: %w", err)
+	}
+
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("json field not found: %s", path)
+		}
+		if value, ok = object[key]; !ok {
+			return "", fmt.Errorf("json field not found: %s", path)
+		}
+	}
+	return fmt.Sprint(value), nil
+}
@@ -0,0 +1,176 @@
+package lock
+
+import (
+	"big-brother/internal/executor"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// FileBackend keeps the lock in a file on the machine running big-brother. A
+// lock left behind by a process of this machine that no longer runs is taken
+// over. Shared makes the directory of the lock writable by every user, for a
+// directory all operators of the machine keep their locks in.
+type FileBackend struct {
+	Path   string
+	Shared bool
+}
+
+func (f FileBackend) TryLock(ctx context.Context, holder Holder) (*Holder, error) {
+	data, err := json.Marshal(holder)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.makeDir(); err != nil {
+		return nil, err
+	}
+
+	// The holder is written to a file of its own first and then linked into
+	// place, which fails when the lock exists, so that the lock file is never
+	// seen half written.
+	temp, err := f.writeTemp(data)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(temp)
+
+	for {
+		err := os.Link(temp, f.Path)
+		if err == nil {
+			return nil, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+
+		current, err := f.read()
+		if err != nil {
+			return nil, err
+		}
+		if current == nil {
+			// Released in the meantime
+			continue
+		}
+		if !f.stale(*current) {
+			return current, nil
+		}
+		if err := f.takeOver(*current); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (f FileBackend) Unlock(ctx context.Context, holder Holder) error {
+	current, err := f.read()
+	if err != nil || current == nil || current.ID != holder.ID {
+		return err
+	}
+	if err := os.Remove(f.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (f FileBackend) ForceUnlock(ctx context.Context) (*Holder, error) {
+	current, _ := f.read()
+	if err := os.Remove(f.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return current, err
+	}
+	return current, nil
+}
+
+func (f FileBackend) String() string {
+	return f.Path
+}
+
+// makeDir creates the directory of the lock file. A shared one is opened up to
+// everyone whoever created it, so that any operator can take and remove locks
+// in it.
+func (f FileBackend) makeDir() error {
+	dir := filepath.Dir(f.Path)
+	if !f.Shared {
+		return os.MkdirAll(dir, 0o755)
+	}
+	if err := os.MkdirAll(dir, 0o777); err != nil {
+		return err
+	}
+	// Past the umask; only the owner can, and once is enough
+	os.Chmod(dir, 0o777)
+	return nil
+}
+
+// writeTemp writes the holder data to a new file next to the lock file and
+// returns its path.
+func (f FileBackend) writeTemp(data []byte) (string, error) {
+	file, err := os.CreateTemp(filepath.Dir(f.Path), filepath.Base(f.Path)+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	// Readable by the operators waiting for the lock
+	err = file.Chmod(0o644)
+	if err == nil {
+		_, err = file.Write(append(data, '\n'))
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+// read returns the holder recorded in the lock file, nil when there is no
+// lock file.
+func (f FileBackend) read() (*Holder, error) {
+	data, err := os.ReadFile(f.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var holder Holder
+	if err := json.Unmarshal(data, &holder); err != nil {
+		return nil, fmt.Errorf("unreadable lock file %s, remove it with --force-unlock: %w", f.Path, err)
+	}
+	return &holder, nil
+}
+
+// takeOver removes the lock file if it still belongs to the stale holder.
+// The check and the removal happen under an exclusive lock on a guard file
+// next to the lock file, so that of several processes finding the same stale
+// lock only one removes it, and none removes the lock another one has taken
+// in the meantime.
+func (f FileBackend) takeOver(stale Holder) error {
+	// Read only, so that operators can use a guard file created by another
+	guard, err := os.OpenFile(f.Path+".guard", os.O_RDONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	defer guard.Close()
+	if err := lockFile(guard); err != nil {
+		return err
+	}
+	defer unlockFile(guard)
+
+	current, err := f.read()
+	if err != nil || current == nil || current.ID != stale.ID {
+		return err
+	}
+	if err := os.Remove(f.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// stale reports whether holder was a process of this machine that is gone.
+func (f FileBackend) stale(holder Holder) bool {
+	host, _ := os.Hostname()
+	return holder.Host == host && holder.PID > 0 && !executor.LocalProcessExists(holder.PID)
+}
@@ -0,0 +1,118 @@
+package lock
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Holder describes who holds a lock.
+type Holder struct {
+	ID      string    `json:"id"`
+	User    string    `json:"user"`
+	Host    string    `json:"host"`
+	PID     int       `json:"pid"`
+	Command string    `json:"command"`
+	Started time.Time `json:"started"`
+}
+
+func (h Holder) String() string {
+	return fmt.Sprintf("%s (pid %d on %s) since %s: %s", h.User, h.PID, h.Host, h.Started.Local().Format(time.DateTime), h.Command)
+}
+
+// NewHolder describes this process as a lock holder.
+func NewHolder(user string, args []string) Holder {
+	id := make([]byte, 8)
+	rand.Read(id)
+	host, _ := os.Hostname()
+	return Holder{
+		ID:      hex.EncodeToString(id),
+		User:    user,
+		Host:    host,
+		PID:     os.Getpid(),
+		Command: strings.Join(args, " "),
+		Started: time.Now(),
+	}
+}
+
+// LockedError is returned when the lock is held by someone else.
+type LockedError struct {
+	Holder Holder
+}
+
+func (e *LockedError) Error() string {
+	return "locked by " + e.Holder.String()
+}
+
+// Backend stores a lock.
+type Backend interface {
+	// TryLock takes the lock for holder if it is free, and otherwise returns
+	// the current holder.
+	TryLock(ctx context.Context, holder Holder) (*Holder, error)
+	// Unlock releases the lock if holder still holds it.
+	Unlock(ctx context.Context, holder Holder) error
+	// ForceUnlock releases the lock whoever holds it, and returns the holder
+	// it had, if any.
+	ForceUnlock(ctx context.Context) (*Holder, error)
+	// String says where the lock is.
+	String() string
+}
+
+// Lock is a lock held by this process.
+type Lock struct {
+	backend Backend
+	holder  Holder
+}
+
+// retryInterval is the time between attempts to take a busy lock.
+const retryInterval = time.Second
+
+// Acquire takes the lock for holder, trying again for up to wait while
+// someone else holds it. waiting is called once when the lock turns out to be
+// busy. The returned error is a *LockedError when the lock stayed busy.
+func Acquire(ctx context.Context, backend Backend, holder Holder, wait time.Duration, waiting func(Holder)) (*Lock, error) {
+	deadline := time.Now().Add(wait)
+	for notified := false; ; notified = true {
+		current, err := backend.TryLock(ctx, holder)
+		if err != nil {
+			return nil, fmt.Errorf("error taking lock %s: %w", backend, err)
+		}
+		if current == nil {
+			return &Lock{backend: backend, holder: holder}, nil
+		}
+		if !time.Now().Before(deadline) {
+			return nil, &LockedError{Holder: *current}
+		}
+		if !notified && waiting != nil {
+			waiting(*current)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(min(retryInterval, time.Until(deadline))):
+		}
+	}
+}
+
+// Unlock releases the lock. It does nothing on a nil lock.
+func (l *Lock) Unlock(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	if err := l.backend.Unlock(ctx, l.holder); err != nil {
+		return fmt.Errorf("error releasing lock %s: %w", l.backend, err)
+	}
+	return nil
+}
+
+// IsLocked reports whether err says the lock is held by someone else.
+func IsLocked(err error) bool {
+	var locked *LockedError
+	return errors.As(err, &locked)
+}
@@ -0,0 +1,96 @@
+package lock
+
+import (
+	"big-brother/internal/executor"
+	"big-brother/internal/models"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// RemoteBackend keeps the lock in a file on another host, created through the
+// executor by hard linking a fully written temporary file into place, so that
+// only one of several concurrent attempts succeeds and nobody reads a half
+// written lock. Shared makes the directory of the lock writable by every user,
+// like for FileBackend.
+type RemoteBackend struct {
+	Executor *executor.Executor
+	HostName string
+	Path     string
+	Timeout  int
+	Shared   bool
+}
+
+// acquired is printed by the lock command when the lock was taken.
+const acquired = "acquired"
+
+func (r RemoteBackend) TryLock(ctx context.Context, holder Holder) (*Holder, error) {
+	data, err := json.Marshal(holder)
+	if err != nil {
+		return nil, err
+	}
+
+	lockPath, lockDir := executor.QuoteWord(r.Path), executor.QuoteWord(path.Dir(r.Path))
+	mkdir := "mkdir -p " + lockDir
+	if r.Shared {
+		mkdir = fmt.Sprintf("mkdir -p %s && { chmod 777 %s 2>/dev/null; true; }", lockDir, lockDir)
+	}
+	line := fmt.Sprintf(
+		"%s && tmp=%s.$$.tmp && printf '%%s\\n' %s > \"$tmp\" && chmod 644 \"$tmp\" && "+
+			"if ln \"$tmp\" %s 2>/dev/null; then rm -f \"$tmp\"; echo %s; else rm -f \"$tmp\"; cat %s 2>/dev/null; fi",
+		mkdir, lockPath, executor.QuoteWord(string(data)), lockPath, acquired, lockPath)
+	for {
+		output, err := r.run(ctx, line)
+		if err != nil {
+			return nil, err
+		}
+		switch strings.TrimSpace(output) {
+		case acquired:
+			return nil, nil
+		case "":
+			// Released in the meantime
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return parseHolder(output, r)
+	}
+}
+
+func (r RemoteBackend) Unlock(ctx context.Context, holder Holder) error {
+	lockPath := executor.QuoteWord(r.Path)
+	_, err := r.run(ctx, fmt.Sprintf("if grep -qF %s %s 2>/dev/null; then rm -f %s; fi",
+		executor.QuoteWord(holder.ID), lockPath, lockPath))
+	return err
+}
+
+func (r RemoteBackend) ForceUnlock(ctx context.Context) (*Holder, error) {
+	lockPath := executor.QuoteWord(r.Path)
+	output, err := r.run(ctx, fmt.Sprintf("cat %s 2>/dev/null; rm -f %s", lockPath, lockPath))
+	if err != nil || strings.TrimSpace(output) == "" {
+		return nil, err
+	}
+	holder, _ := parseHolder(output, r)
+	return holder, nil
+}
+
+func (r RemoteBackend) String() string {
+	return r.Path + " on " + r.HostName
+}
+
+func (r RemoteBackend) run(ctx context.Context, line string) (string, error) {
+	process := &models.Process{Name: "lock", HostName: r.HostName, Shell: true, Timeout: r.Timeout}
+	ctx = executor.WithLabels(ctx, executor.Labels{Action: executor.ActionLock})
+	return r.Executor.ExecuteProcessCommand(ctx, process, line)
+}
+
+func parseHolder(output string, backend Backend) (*Holder, error) {
+	var holder Holder
+	if err := json.Unmarshal([]byte(strings.TrimSpace(output)), &holder); err != nil {
+		return nil, fmt.Errorf("unreadable lock file %s, remove it with --force-unlock: %w", backend, err)
+	}
+	return &holder, nil
+}
@@ -1,46 +1,252 @@
 package logger
 
 import (
-	"log"
+	"big-brother/internal/rotate"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
+// Level is the severity of a log message.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	}
+	return strconv.Itoa(int(l))
+}
+
+// ParseLevel parses debug, info, warn or error.
+func ParseLevel(name string) (Level, error) {
+	for level := LevelDebug; level <= LevelError; level++ {
+		if strings.EqualFold(name, level.String()) {
+			return level, nil
+		}
+	}
+	if strings.EqualFold(name, "warning") {
+		return LevelWarn, nil
+	}
+	return 0, fmt.Errorf("unknown log level '%s', expected debug, info, warn or error", name)
+}
+
+// Format is how log lines are written.
+type Format string
+
+const (
+	FormatText Format = "text"
+	FormatJSON Format = "json"
+)
+
+// Options configure a Logger.
+type Options struct {
+	Level  Level
+	Format Format
+	// Output receives the log lines when no File is set. Defaults to stderr.
+	Output io.Writer
+	// File is a log file written instead of Output, rotated once it grows
+	// past MaxSize megabytes, keeping MaxFiles rotated files.
+	File     string
+	MaxSize  int
+	MaxFiles int
+}
+
+// Logger writes leveled log lines, each with the fields attached by With.
+// It is safe for concurrent use.
 type Logger struct {
-	Verbose bool
-	logger  *log.Logger
+	level  Level
+	format Format
+	out    *output
+	fields []field
+}
+
+type field struct {
+	key   string
+	value any
+}
+
+// output is the destination shared by a Logger and those derived from it.
+type output struct {
+	mu     sync.Mutex
+	writer io.Writer
+	closer io.Closer
+}
+
+// New returns a Logger configured by opts.
+func New(opts Options) (*Logger, error) {
+	switch opts.Format {
+	case "":
+		opts.Format = FormatText
+	case FormatText, FormatJSON:
+	default:
+		return nil, fmt.Errorf("unknown log format '%s', expected text or json", opts.Format)
+	}
+
+	out := &output{writer: opts.Output}
+	if out.writer == nil {
+		out.writer = os.Stderr
+	}
+	if opts.File != "" {
+		file, err := rotate.Open(opts.File, int64(opts.MaxSize)<<20, opts.MaxFiles)
+		if err != nil {
+			return nil, fmt.Errorf("error opening log file: %w", err)
+		}
+		out.writer, out.closer = file, file
+	}
+	return &Logger{level: opts.Level, format: opts.Format, out: out}, nil
 }
 
+// NewLogger returns a text Logger writing to stderr, at debug level when
+// verbose and at warn level otherwise.
 func NewLogger(verbose bool) *Logger {
-	return &Logger{
-		Verbose: verbose,
-		logger:  log.New(os.Stdout, "", log.LstdFlags),
+	level := LevelWarn
+	if verbose {
+		level = LevelDebug
 	}
+	l, _ := New(Options{Level: level})
+	return l
 }
 
-func (l *Logger) Info(msg string) {
-	if l.Verbose {
-		l.logger.Println("[INFO] ", msg)
+// With returns a Logger adding key=value to every line. An empty value is
+// left out.
+func (l *Logger) With(key string, value any) *Logger {
+	if value == "" || value == nil {
+		return l
+	}
+	derived := *l
+	derived.fields = append(l.fields[:len(l.fields):len(l.fields)], field{key, value})
+	return &derived
+}
+
+// Enabled reports whether messages at level are written.
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.level
+}
+
+// Close closes the log file, if any.
+func (l *Logger) Close() error {
+	if l.out.closer == nil {
+		return nil
+	}
+	return l.out.closer.Close()
+}
+
+func (l *Logger) Debug(msg string) {
+	l.write(LevelDebug, "DEBUG", msg)
+}
+
+func (l *Logger) Debugf(format string, v ...interface{}) {
+	if l.Enabled(LevelDebug) {
+		l.write(LevelDebug, "DEBUG", fmt.Sprintf(format, v...))
 	}
 }
 
+func (l *Logger) Info(msg string) {
+	l.write(LevelInfo, "INFO", msg)
+}
+
 func (l *Logger) Infof(format string, v ...interface{}) {
-	if l.Verbose {
-		l.logger.Printf("[INFO] "+format, v...)
+	if l.Enabled(LevelInfo) {
+		l.write(LevelInfo, "INFO", fmt.Sprintf(format, v...))
+	}
+}
+
+func (l *Logger) Warn(msg string) {
+	l.write(LevelWarn, "WARN", msg)
+}
+
+func (l *Logger) Warnf(format string, v ...interface{}) {
+	if l.Enabled(LevelWarn) {
+		l.write(LevelWarn, "WARN", fmt.Sprintf(format, v...))
 	}
 }
 
 func (l *Logger) Error(msg string) {
-	l.logger.Println("[ERROR] ", msg)
+	l.write(LevelError, "ERROR", msg)
 }
 
 func (l *Logger) Errorf(format string, v ...interface{}) {
-	l.logger.Printf("[ERROR] "+format, v...)
+	l.write(LevelError, "ERROR", fmt.Sprintf(format, v...))
 }
 
+// Fatal logs msg at error level. Unlike log.Fatal it does not exit; the
+// caller decides how to stop, so that deferred cleanup still runs.
 func (l *Logger) Fatal(msg string) {
-	l.logger.Fatalf("[FATAL] %s", msg)
+	l.write(LevelError, "FATAL", msg)
 }
 
+// Fatalf is Fatal with a format string.
 func (l *Logger) Fatalf(format string, v ...interface{}) {
-	l.logger.Fatalf("[FATAL] "+format, v...)
+	l.write(LevelError, "FATAL", fmt.Sprintf(format, v...))
+}
+
+func (l *Logger) write(level Level, label, msg string) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	now := time.Now()
+	var line bytes.Buffer
+	if l.format == FormatJSON {
+		line.WriteString(`{"time":`)
+		writeJSON(&line, now.Format(time.RFC3339Nano))
+		line.WriteString(`,"level":`)
+		writeJSON(&line, strings.ToLower(label))
+		line.WriteString(`,"msg":`)
+		writeJSON(&line, msg)
+		for _, f := range l.fields {
+			line.WriteByte(',')
+			writeJSON(&line, f.key)
+			line.WriteByte(':')
+			writeJSON(&line, f.value)
+		}
+		line.WriteString("}\n")
+	} else {
+		fmt.Fprintf(&line, "%s [%s] %s", now.Format("2006/01/02 15:04:05"), label, strings.TrimRight(msg, "\n"))
+		for _, f := range l.fields {
+			fmt.Fprintf(&line, " %s=%s", f.key, textValue(f.value))
+		}
+		line.WriteByte('\n')
+	}
+
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	l.out.writer.Write(line.Bytes())
+}
+
+func writeJSON(buf *bytes.Buffer, value any) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(value))
+	}
+	buf.Write(data)
+}
+
+// textValue quotes values that would not read back as a single word.
+func textValue(value any) string {
+	text := fmt.Sprint(value)
+	if text == "" || strings.ContainsAny(text, " \t\n\"=") {
+		return strconv.Quote(text)
+	}
+	return text
 }
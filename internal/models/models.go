@@ -3,42 +3,379 @@ package models
 import (
 	"fmt"
 	"strings"
+	"time"
 )
 
 type Config struct {
-	WaitTime       int       `yaml:"wait_time"`
-	Services       []Service `yaml:"services"`
+	WaitTime       int           `yaml:"wait_time"`
+	Timeout        int           `yaml:"timeout"`
+	ReadyTimeout   int           `yaml:"ready_timeout"`
+	PollInterval   int           `yaml:"poll_interval"`
+	InitialDelay   int           `yaml:"initial_delay"`
+	FailurePolicy  FailurePolicy `yaml:"failure_policy"`
+	CheckInterval  int           `yaml:"check_interval"`
+	StateDir       string        `yaml:"state_dir"`
+	Lock           LockConfig    `yaml:"lock"`
+	Audit          AuditConfig   `yaml:"audit"`
+	SSH            SSHConfig     `yaml:"ssh"`
+	Hosts          []Host        `yaml:"hosts"`
+	Services       []Service     `yaml:"services"`
 	DependencyTree []*Service
+	StartOrder     []*Service
+}
+
+// FailurePolicy decides what a start or stop run does after a service fails.
+type FailurePolicy string
+
+const (
+	// FailFast starts nothing new once a service fails.
+	FailFast FailurePolicy = "fail-fast"
+	// ContinueOnFailure skips only the services that depend on a failed one
+	// and carries on with independent branches.
+	ContinueOnFailure FailurePolicy = "continue"
+	// RollbackOnFailure fails fast and then stops whatever the start run
+	// itself started.
+	RollbackOnFailure FailurePolicy = "rollback"
+)
+
+// DependencyList holds the names of the services a service depends on. In
+// YAML it can be a single name or a list of names.
+type DependencyList []string
+
+func (d *DependencyList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var name string
+	if err := unmarshal(&name); err == nil {
+		*d = nil
+		if name != "" {
+			*d = DependencyList{name}
+		}
+		return nil
+	}
+
+	var names []string
+	if err := unmarshal(&names); err != nil {
+		return fmt.Errorf("depends_on must be a service name or a list of service names: %w", err)
+	}
+	*d = names
+	return nil
+}
+
+// Hooks are commands run around starting and stopping a service or process.
+type Hooks struct {
+	PreStart  *Hook `yaml:"pre_start"`
+	PostStart *Hook `yaml:"post_start"`
+	PreStop   *Hook `yaml:"pre_stop"`
+	PostStop  *Hook `yaml:"post_stop"`
+}
+
+// Hook is a command run on the host of its process, or on HostName for
+// service hooks (localhost by default). A failing hook aborts the start or
+// stop unless OnFailure is warn. In YAML a hook can also be just the command.
+type Hook struct {
+	Cmd       string      `yaml:"cmd"`
+	HostName  string      `yaml:"host_name"`
+	Shell     bool        `yaml:"shell"`
+	Timeout   int         `yaml:"timeout"`
+	OnFailure HookFailure `yaml:"on_failure"`
+}
+
+type HookFailure string
+
+const (
+	HookAbort HookFailure = "abort"
+	HookWarn  HookFailure = "warn"
+)
+
+func (h *Hook) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var cmd string
+	if err := unmarshal(&cmd); err == nil {
+		*h = Hook{Cmd: cmd}
+		return nil
+	}
+
+	type plain Hook
+	return unmarshal((*plain)(h))
+}
+
+// LockConfig says where the run lock lives: a file on the machine running
+// big-brother, or with HostName a file on that host, reached through its
+// runner. Name replaces the default file name derived from the config.
+type LockConfig struct {
+	HostName string `yaml:"host_name"`
+	Path     string `yaml:"path"`
+	Name     string `yaml:"name"`
+}
+
+// AuditConfig says where the audit trail of executed commands goes. Sizes
+// are in megabytes, OutputLimit in bytes.
+type AuditConfig struct {
+	File        string `yaml:"file"`
+	MaxSize     int    `yaml:"max_size"`
+	MaxFiles    int    `yaml:"max_files"`
+	Syslog      bool   `yaml:"syslog"`
+	SyslogTag   string `yaml:"syslog_tag"`
+	OutputLimit int    `yaml:"output_limit"`
+	Status      bool   `yaml:"status"`
+}
+
+// SSHConfig holds the defaults used to reach remote hosts.
+type SSHConfig struct {
+	User       string `yaml:"user"`
+	Port       int    `yaml:"port"`
+	KeyFile    string `yaml:"key_file"`
+	KnownHosts string `yaml:"known_hosts"`
+}
+
+// Host overrides the runner and SSH defaults for a single host_name.
+type Host struct {
+	Name      string `yaml:"name"`
+	Runner    string `yaml:"runner"`
+	Address   string `yaml:"address"`
+	User      string `yaml:"user"`
+	Port      int    `yaml:"port"`
+	KeyFile   string `yaml:"key_file"`
+	Container string `yaml:"container"`
 }
 
 type Service struct {
-	Name         string    `yaml:"name"`
-	DependsOn    string    `yaml:"depends_on"`
-	Processes    []Process `yaml:"processes"`
+	Name         string         `yaml:"name"`
+	DependsOn    DependencyList `yaml:"depends_on"`
+	Timeout      int            `yaml:"timeout"`
+	ReadyTimeout int            `yaml:"ready_timeout"`
+	PollInterval int            `yaml:"poll_interval"`
+	InitialDelay int            `yaml:"initial_delay"`
+	Hooks        Hooks          `yaml:",inline"`
+	Processes    []Process      `yaml:"processes"`
 	Dependents   []*Service
 	Dependencies []*Service
 }
 
 type Process struct {
-	Name      string `yaml:"name"`
-	HostName  string `yaml:"host_name"`
-	StartCmd  string `yaml:"start_cmd"`
-	StopCmd   string `yaml:"stop_cmd"`
-	StatusCmd string `yaml:"status_cmd"`
+	Name         string         `yaml:"name"`
+	HostName     string         `yaml:"host_name"`
+	Runner       string         `yaml:"runner"`
+	Timeout      int            `yaml:"timeout"`
+	ReadyTimeout int            `yaml:"ready_timeout"`
+	PollInterval int            `yaml:"poll_interval"`
+	InitialDelay int            `yaml:"initial_delay"`
+	StopTimeout  int            `yaml:"stop_timeout"`
+	Shell        bool           `yaml:"shell"`
+	StartCmd     string         `yaml:"start_cmd"`
+	StopCmd      string         `yaml:"stop_cmd"`
+	StatusCmd    string         `yaml:"status_cmd"`
+	KillCmd      string         `yaml:"kill_cmd"`
+	Argv         ProcessArgv    `yaml:"argv"`
+	Status       *StatusPolicy  `yaml:"status"`
+	Health       *HealthCheck   `yaml:"health"`
+	Retry        *RetryPolicy   `yaml:"retry"`
+	Restart      *RestartPolicy `yaml:"restart_policy"`
+	Hooks        Hooks          `yaml:",inline"`
+}
+
+// HealthCheck declares built-in probes evaluated instead of status_cmd. Every
+// probe that is set must pass for the process to be running.
+type HealthCheck struct {
+	HTTP        *HTTPCheck `yaml:"http"`
+	TCP         string     `yaml:"tcp"`
+	PIDFile     string     `yaml:"pidfile"`
+	ProcessName string     `yaml:"process_name"`
+}
+
+type HTTPCheck struct {
+	URL          string `yaml:"url"`
+	ExpectStatus int    `yaml:"expect_status"`
+	BodyRegex    string `yaml:"body_regex"`
 }
 
+// RetryPolicy decides how often a failed start, stop or status command is
+// run again. Delays are in seconds.
+type RetryPolicy struct {
+	Attempts  int     `yaml:"attempts"`
+	Backoff   Backoff `yaml:"backoff"`
+	Delay     int     `yaml:"delay"`
+	MaxDelay  int     `yaml:"max_delay"`
+	Jitter    float64 `yaml:"jitter"`
+	ExitCodes []int   `yaml:"exit_codes"`
+	OnTimeout bool    `yaml:"on_timeout"`
+}
+
+type Backoff string
+
+const (
+	BackoffFixed       Backoff = "fixed"
+	BackoffExponential Backoff = "exponential"
+)
+
+// RestartPolicy decides whether supervise restarts a process it finds
+// stopped. A process is given up on once it needed MaxRestarts restarts within
+// Window seconds, until it is seen running again. Delay, in seconds, is the
+// least time between two restarts and grows with exponential backoff. In YAML
+// a policy can also be just the mode.
+type RestartPolicy struct {
+	Mode        RestartMode `yaml:"mode"`
+	MaxRestarts int         `yaml:"max_restarts"`
+	Window      int         `yaml:"window"`
+	Backoff     Backoff     `yaml:"backoff"`
+	Delay       int         `yaml:"delay"`
+	MaxDelay    int         `yaml:"max_delay"`
+}
+
+type RestartMode string
+
+const (
+	// RestartNever leaves the process alone; supervise only reports it.
+	RestartNever RestartMode = "never"
+	// RestartOnFailure restarts the process once it went down after having
+	// been seen running.
+	RestartOnFailure RestartMode = "on-failure"
+	// RestartAlways restarts the process whenever it is found stopped,
+	// including when it was already down when supervise started.
+	RestartAlways RestartMode = "always"
+)
+
+func (r *RestartPolicy) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var mode string
+	if err := unmarshal(&mode); err == nil {
+		*r = RestartPolicy{Mode: RestartMode(mode)}
+		return nil
+	}
+
+	type plain RestartPolicy
+	return unmarshal((*plain)(r))
+}
+
+// ProcessArgv holds exact argument lists used instead of the command lines.
+type ProcessArgv struct {
+	Start  []string `yaml:"start"`
+	Stop   []string `yaml:"stop"`
+	Status []string `yaml:"status"`
+	Kill   []string `yaml:"kill"`
+}
+
+// StatusPolicy decides the state of a process from the result of its status
+// command. Exit codes are checked first: running_exit_codes defaults to [0],
+// and any other code means stopped unless stopped_exit_codes or
+// degraded_exit_codes are listed, in which case unlisted codes are unknown.
+// For a running exit code, degraded_match marks the process degraded, and
+// every output predicate that is set must hold or the process is stopped.
+type StatusPolicy struct {
+	RunningExitCodes  []int    `yaml:"running_exit_codes"`
+	StoppedExitCodes  []int    `yaml:"stopped_exit_codes"`
+	DegradedExitCodes []int    `yaml:"degraded_exit_codes"`
+	StdoutMatch       string   `yaml:"stdout_match"`
+	StdoutNotMatch    string   `yaml:"stdout_not_match"`
+	StderrMatch       string   `yaml:"stderr_match"`
+	StderrNotMatch    string   `yaml:"stderr_not_match"`
+	DegradedMatch     string   `yaml:"degraded_match"`
+	JSONField         string   `yaml:"json_field"`
+	JSONEquals        []string `yaml:"json_equals"`
+}
+
+// ProcessState is the observed state of a process.
+type ProcessState string
+
+const (
+	StateRunning  ProcessState = "running"
+	StateStopped  ProcessState = "stopped"
+	StateDegraded ProcessState = "degraded"
+	StateUnknown  ProcessState = "unknown"
+	StateTimedOut ProcessState = "timed_out"
+)
+
 type CheckResult struct {
-	ServiceName string `json:"service_name"`
-	ProcessName string `json:"process_name"`
-	HostName    string `json:"host_name"`
-	IsRunning   bool   `json:"is_running"`
+	ServiceName string       `json:"service_name"`
+	ProcessName string       `json:"process_name"`
+	HostName    string       `json:"host_name"`
+	State       ProcessState `json:"state"`
+	IsRunning   bool         `json:"is_running"`
+}
+
+// Plan is what a start, stop or restart run would do, as shown by --dry-run.
+// Concurrency is the most services the run works on at once.
+type Plan struct {
+	Command     string      `json:"command"`
+	Concurrency int         `json:"concurrency"`
+	Phases      []PlanPhase `json:"phases"`
+}
+
+// PlanPhase runs one action over the dependency levels of services. Every
+// service comes in a later level than the services it waits for. A service
+// starts as soon as those have finished, up to the plan's concurrency, so the
+// services of a level are not necessarily run together.
+type PlanPhase struct {
+	Action string             `json:"action"`
+	Levels [][]PlannedService `json:"levels"`
+}
+
+type PlannedService struct {
+	Name  string        `json:"name"`
+	Steps []PlannedStep `json:"steps"`
+}
+
+// PlannedStep is a command that would run for a process, rendered as it
+// would be executed by the runner. Hook is set for the steps that run a
+// service's or process's hook; Process is then the service's name for
+// service hooks.
+type PlannedStep struct {
+	Hook    string `json:"hook,omitempty"`
+	Process string `json:"process"`
+	Host    string `json:"host"`
+	Runner  string `json:"runner"`
+	Command string `json:"command"`
+}
+
+// Run is the record of one start, stop, restart, rolling-restart or
+// supervise restart, as kept by the state store and shown by history.
+type Run struct {
+	ID       string          `json:"id"`
+	Command  string          `json:"command"`
+	Service  string          `json:"service,omitempty"`
+	Process  string          `json:"process,omitempty"`
+	Config   string          `json:"config"`
+	User     string          `json:"user"`
+	Args     []string        `json:"args"`
+	Started  time.Time       `json:"started"`
+	Finished time.Time       `json:"finished"`
+	Status   string          `json:"status"`
+	Error    string          `json:"error,omitempty"`
+	Results  []RunResult     `json:"results"`
+	Commands []CommandRecord `json:"commands"`
+}
+
+// RunResult is the outcome of a service, or of a batch of a rolling restart,
+// within a run.
+type RunResult struct {
+	Name     string        `json:"name"`
+	Status   string        `json:"status"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+	Notes    []string      `json:"notes,omitempty"`
+}
+
+// CommandRecord is a command the executor ran. Service, Process and Action
+// are empty when the command was not run for one. Attempt numbers the runs
+// of a process action. OutputFile holds the output of the command when it
+// was saved.
+type CommandRecord struct {
+	Service    string        `json:"service,omitempty"`
+	Process    string        `json:"process,omitempty"`
+	Action     string        `json:"action,omitempty"`
+	Host       string        `json:"host"`
+	Runner     string        `json:"runner"`
+	Command    string        `json:"command"`
+	Started    time.Time     `json:"started"`
+	Duration   time.Duration `json:"duration"`
+	ExitCode   int           `json:"exit_code"`
+	Error      string        `json:"error,omitempty"`
+	Attempt    int           `json:"attempt,omitempty"`
+	OutputFile string        `json:"output_file,omitempty"`
 }
 
 func (s *Service) String() string {
 	var sb strings.Builder
 	sb.WriteString(fmt.Sprintf("Service(Name=%s", s.Name))
-	if s.DependsOn != "" {
-		sb.WriteString(fmt.Sprintf(", DependsOn=%s", s.DependsOn))
+	if len(s.DependsOn) > 0 {
+		sb.WriteString(fmt.Sprintf(", DependsOn=%v", []string(s.DependsOn)))
 	}
 	if len(s.Dependencies) > 0 {
 		var deps []string
@@ -0,0 +1,224 @@
+package scheduler
+
+import (
+	"big-brother/internal/models"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type Status string
+
+const (
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+	StatusSkipped   Status = "skipped"
+	// StatusRolledBack marks a service that succeeded and was then undone
+	// because the run failed elsewhere.
+	StatusRolledBack     Status = "rolled back"
+	StatusRollbackFailed Status = "rollback failed"
+	// StatusAlreadyRunning and StatusAlreadyStopped mark a service the action
+	// left alone because it was already in the desired state.
+	StatusAlreadyRunning Status = "already running"
+	StatusAlreadyStopped Status = "already stopped"
+)
+
+// Satisfied is returned by an action that found nothing to do. The service
+// gets Status and counts as succeeded.
+type Satisfied struct {
+	Status Status
+}
+
+func (s Satisfied) Error() string {
+	return string(s.Status)
+}
+
+// Result is the outcome of running an action on one service. Skipped
+// services carry the reason in Err. Notes are the remarks the action recorded
+// with Note.
+type Result struct {
+	Service  *models.Service
+	Status   Status
+	Err      error
+	Duration time.Duration
+	Notes    []string
+}
+
+// Succeeded reports whether the service ended up in the state the action
+// aimed for, whether or not the action had to do anything.
+func (r Result) Succeeded() bool {
+	switch r.Status {
+	case StatusSucceeded, StatusAlreadyRunning, StatusAlreadyStopped:
+		return true
+	}
+	return false
+}
+
+// Action is run once for each scheduled service.
+type Action func(ctx context.Context, service *models.Service) error
+
+// Prerequisites returns the services that must succeed before service runs.
+type Prerequisites func(service *models.Service) []*models.Service
+
+type completion struct {
+	index    int
+	err      error
+	duration time.Duration
+	notes    []string
+}
+
+type notesKey struct{}
+
+type noteList struct {
+	mu    sync.Mutex
+	notes []string
+}
+
+// Note records a remark, such as a forced kill, on the result of the service
+// whose action was given ctx. It does nothing for a ctx that doesn't come
+// from Run.
+func Note(ctx context.Context, format string, args ...any) {
+	list, ok := ctx.Value(notesKey{}).(*noteList)
+	if !ok {
+		return
+	}
+	list.mu.Lock()
+	defer list.mu.Unlock()
+	list.notes = append(list.notes, fmt.Sprintf(format, args...))
+}
+
+// Run runs action for every service as soon as all of its prerequisites have
+// succeeded, with at most concurrency actions running at once. Prerequisites
+// outside services are ignored. When an action fails, everything that
+// (transitively) needs that service is skipped, and with failFast nothing new
+// is started at all while running actions finish. When ctx is done, nothing
+// new is started either. Results are returned in the order of services.
+func Run(ctx context.Context, services []*models.Service, prerequisites Prerequisites, concurrency int, failFast bool, action Action) []Result {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	index := make(map[*models.Service]int)
+	for i, service := range services {
+		index[service] = i
+	}
+
+	// Count prerequisites inside the set and record who waits on whom
+	pending := make([]int, len(services))
+	waiting := make([][]int, len(services))
+	for i, service := range services {
+		for _, prerequisite := range prerequisites(service) {
+			if j, ok := index[prerequisite]; ok {
+				pending[i]++
+				waiting[j] = append(waiting[j], i)
+			}
+		}
+	}
+
+	results := make([]Result, len(services))
+	finished := make([]bool, len(services))
+	var ready []int
+	for i, service := range services {
+		results[i].Service = service
+		if pending[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+
+	var skip func(i int, reason error)
+	skip = func(i int, reason error) {
+		if finished[i] {
+			return
+		}
+		finished[i] = true
+		results[i].Status = StatusSkipped
+		results[i].Err = reason
+		for _, j := range waiting[i] {
+			skip(j, reason)
+		}
+	}
+
+	completions := make(chan completion)
+	running := 0
+	var abort error
+	for {
+		for running < concurrency && len(ready) > 0 && abort == nil && ctx.Err() == nil {
+			i := ready[0]
+			ready = ready[1:]
+			running++
+			go func(i int) {
+				notes := &noteList{}
+				started := time.Now()
+				err := action(context.WithValue(ctx, notesKey{}, notes), services[i])
+				duration := time.Since(started)
+
+				notes.mu.Lock()
+				recorded := notes.notes
+				notes.mu.Unlock()
+				completions <- completion{index: i, err: err, duration: duration, notes: recorded}
+			}(i)
+		}
+		if running == 0 {
+			break
+		}
+
+		done := <-completions
+		running--
+		i := done.index
+		finished[i] = true
+		results[i].Duration = done.duration
+		results[i].Notes = done.notes
+		var satisfied Satisfied
+		switch {
+		case errors.As(done.err, &satisfied):
+			results[i].Status = satisfied.Status
+		case done.err != nil:
+			results[i].Status = StatusFailed
+			results[i].Err = done.err
+			for _, j := range waiting[i] {
+				skip(j, fmt.Errorf("dependency %s failed", services[i].Name))
+			}
+			if failFast && abort == nil {
+				abort = fmt.Errorf("run aborted after %s failed", services[i].Name)
+			}
+			continue
+		default:
+			results[i].Status = StatusSucceeded
+		}
+
+		for _, j := range waiting[i] {
+			pending[j]--
+			if pending[j] == 0 && !finished[j] {
+				ready = append(ready, j)
+			}
+		}
+	}
+
+	// Anything left was never started because the run was aborted or ctx was done
+	reason := abort
+	if reason == nil {
+		reason = ctx.Err()
+	}
+	if reason == nil {
+		reason = errors.New("not started")
+	}
+	for i := range services {
+		if !finished[i] {
+			skip(i, reason)
+		}
+	}
+	return results
+}
+
+// Failed returns the results that did not succeed.
+func Failed(results []Result) []Result {
+	var failed []Result
+	for _, result := range results {
+		if !result.Succeeded() {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
@@ -0,0 +1,148 @@
+package state
+
+import (
+	"big-brother/internal/models"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Store keeps the record of every run as a JSON file under its directory.
+// Run IDs start with the start time, so the file names sort by age.
+type Store struct {
+	dir string
+}
+
+// Filter selects runs for List. Zero fields match everything.
+type Filter struct {
+	Service string
+	Since   time.Time
+	Until   time.Time
+	Limit   int
+}
+
+// Open returns the store in dir, creating the directory if needed.
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "runs"), 0o755); err != nil {
+		return nil, fmt.Errorf("error creating state directory: %w", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Dir returns the directory of the store.
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+// OutputDir returns the directory keeping the output of the commands of the
+// run with the given ID.
+func (s *Store) OutputDir(id string) string {
+	return filepath.Join(s.dir, "output", id)
+}
+
+// NewRunID returns a unique, time-ordered run ID.
+func NewRunID(started time.Time) string {
+	suffix := make([]byte, 3)
+	rand.Read(suffix)
+	return started.UTC().Format("20060102T150405.000000Z") + "-" + hex.EncodeToString(suffix)
+}
+
+// Save writes run, replacing any earlier record of it.
+func (s *Store) Save(run *models.Run) error {
+	data, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write and rename, so that a crash never leaves half a record behind
+	path := s.runPath(run.ID)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("error saving run %s: %w", run.ID, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("error saving run %s: %w", run.ID, err)
+	}
+	return nil
+}
+
+// Load returns the run with the given ID.
+func (s *Store) Load(id string) (*models.Run, error) {
+	if id == "" || strings.ContainsAny(id, `/\`) {
+		return nil, fmt.Errorf("invalid run id: %s", id)
+	}
+	data, err := os.ReadFile(s.runPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("run not found: %s", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var run models.Run
+	if err := json.Unmarshal(data, &run); err != nil {
+		return nil, fmt.Errorf("error reading run %s: %w", id, err)
+	}
+	return &run, nil
+}
+
+// List returns the runs matching filter, newest first.
+func (s *Store) List(filter Filter) ([]*models.Run, error) {
+	names, err := filepath.Glob(filepath.Join(s.dir, "runs", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(names)
+	slices.Reverse(names)
+
+	var runs []*models.Run
+	for _, name := range names {
+		if filter.Limit > 0 && len(runs) == filter.Limit {
+			break
+		}
+		run, err := s.Load(strings.TrimSuffix(filepath.Base(name), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		if filter.matches(run) {
+			runs = append(runs, run)
+		}
+	}
+	return runs, nil
+}
+
+func (s *Store) runPath(id string) string {
+	return filepath.Join(s.dir, "runs", id+".json")
+}
+
+// matches reports whether run started within the filter's time range and, if
+// the filter names a service, touched that service.
+func (f Filter) matches(run *models.Run) bool {
+	if !f.Since.IsZero() && run.Started.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && run.Started.After(f.Until) {
+		return false
+	}
+	if f.Service == "" || run.Service == f.Service {
+		return true
+	}
+	for _, result := range run.Results {
+		if result.Name == f.Service {
+			return true
+		}
+	}
+	for _, command := range run.Commands {
+		if command.Service == f.Service {
+			return true
+		}
+	}
+	return false
+}
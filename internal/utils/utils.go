@@ -1,15 +1,22 @@
 package utils
 
 import (
+	"big-brother/internal/executor"
 	"big-brother/internal/models"
 	"errors"
 	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
 )
 
 func ValidateConfigAndBuildDependencyTree(cfg *models.Config) error {
 	if err := validateConfig(cfg); err != nil {
 		return err
 	}
+	applyDefaults(cfg)
 
 	graph, err := createDependencyGraph(cfg)
 	if err != nil {
@@ -20,110 +27,428 @@ func ValidateConfigAndBuildDependencyTree(cfg *models.Config) error {
 		return errors.New("cyclic dependency detected in config")
 	}
 
-	sortedServices, err := topologicalSort(graph, cfg)
-	if err != nil {
-		return err
-	}
+	cfg.StartOrder = topologicalSort(graph, cfg)
+	linkServices(cfg)
 
-	// Clear the DependencyTree and only add root nodes
+	// The DependencyTree holds the root nodes, the rest hang off Dependents
 	var rootNodes []*models.Service
-	for _, service := range sortedServices {
-		if service.DependsOn == "" {
+	for _, service := range cfg.StartOrder {
+		if len(service.Dependencies) == 0 {
 			rootNodes = append(rootNodes, service)
 		}
 	}
 	cfg.DependencyTree = rootNodes
 
-	// Populate Dependents and Dependencies for each service
-	for _, service := range cfg.DependencyTree {
-		populateDependents(service, sortedServices)
+	return nil
+}
+
+// linkServices populates Dependencies and Dependents of every service with
+// pointers into cfg.Services.
+func linkServices(cfg *models.Config) {
+	byName := make(map[string]*models.Service)
+	for i := range cfg.Services {
+		service := &cfg.Services[i]
+		service.Dependencies = nil
+		service.Dependents = nil
+		byName[service.Name] = service
 	}
 
-	return nil
+	for i := range cfg.Services {
+		service := &cfg.Services[i]
+		for _, dependencyName := range service.DependsOn {
+			dependency := byName[dependencyName]
+			service.Dependencies = append(service.Dependencies, dependency)
+			dependency.Dependents = append(dependency.Dependents, service)
+		}
+	}
 }
 
-func populateDependents(parent *models.Service, services []*models.Service) {
-	for _, service := range services {
-		if service.DependsOn == parent.Name {
-			parent.Dependents = append(parent.Dependents, service)
-			populateDependents(service, services) // Recursively populate the child nodes
+// ValidateFailurePolicy accepts the known policies, or empty for the default.
+func ValidateFailurePolicy(policy models.FailurePolicy) error {
+	switch policy {
+	case "", models.FailFast, models.ContinueOnFailure, models.RollbackOnFailure:
+		return nil
+	}
+	return fmt.Errorf("unknown failure policy: %s", policy)
+}
+
+// applyDefaults resolves settings that can be given at global, service or
+// process level down to each process, the most specific one winning.
+func applyDefaults(cfg *models.Config) {
+	if cfg.FailurePolicy == "" {
+		cfg.FailurePolicy = models.FailFast
+	}
+
+	// wait_time predates readiness polling and now only serves as the
+	// default ready_timeout
+	if cfg.ReadyTimeout == 0 {
+		cfg.ReadyTimeout = cfg.WaitTime
+	}
+	if cfg.ReadyTimeout == 0 {
+		cfg.ReadyTimeout = defaultReadyTimeout
+	}
+	if cfg.PollInterval == 0 {
+		cfg.PollInterval = defaultPollInterval
+	}
+	if cfg.CheckInterval == 0 {
+		cfg.CheckInterval = defaultCheckInterval
+	}
+	if cfg.Audit.MaxSize == 0 {
+		cfg.Audit.MaxSize = defaultAuditMaxSize
+	}
+	if cfg.Audit.MaxFiles == 0 {
+		cfg.Audit.MaxFiles = defaultAuditMaxFiles
+	}
+	if cfg.Audit.SyslogTag == "" {
+		cfg.Audit.SyslogTag = "big-brother"
+	}
+	if cfg.Audit.OutputLimit == 0 {
+		cfg.Audit.OutputLimit = defaultAuditOutputLimit
+	}
+
+	for i := range cfg.Services {
+		service := &cfg.Services[i]
+		for j := range service.Processes {
+			process := &service.Processes[j]
+			process.Timeout = firstNonZero(process.Timeout, service.Timeout, cfg.Timeout)
+			process.ReadyTimeout = firstNonZero(process.ReadyTimeout, service.ReadyTimeout, cfg.ReadyTimeout)
+			process.PollInterval = firstNonZero(process.PollInterval, service.PollInterval, cfg.PollInterval)
+			process.InitialDelay = firstNonZero(process.InitialDelay, service.InitialDelay, cfg.InitialDelay)
+			process.StopTimeout = firstNonZero(process.StopTimeout, process.ReadyTimeout)
+			if process.Retry != nil && process.Retry.Delay == 0 {
+				process.Retry.Delay = defaultRetryDelay
+			}
+			applyRestartDefaults(process.Restart)
+			applyHookDefaults(&process.Hooks, process.HostName, process.Timeout)
+		}
+		applyHookDefaults(&service.Hooks, "localhost", firstNonZero(service.Timeout, cfg.Timeout))
+	}
+}
+
+// applyHookDefaults fills in the host and timeout of hooks that don't set
+// their own.
+func applyHookDefaults(hooks *models.Hooks, hostName string, timeout int) {
+	for _, hook := range []*models.Hook{hooks.PreStart, hooks.PostStart, hooks.PreStop, hooks.PostStop} {
+		if hook == nil {
+			continue
 		}
+		if hook.HostName == "" {
+			hook.HostName = hostName
+		}
+		hook.Timeout = firstNonZero(hook.Timeout, timeout)
+		if hook.OnFailure == "" {
+			hook.OnFailure = models.HookAbort
+		}
+	}
+}
+
+// applyRestartDefaults fills in the mode and restart limit of a restart
+// policy that doesn't set its own.
+func applyRestartDefaults(restart *models.RestartPolicy) {
+	if restart == nil {
+		return
+	}
+	if restart.Mode == "" {
+		restart.Mode = models.RestartOnFailure
+	}
+	if restart.MaxRestarts == 0 {
+		restart.MaxRestarts = defaultMaxRestarts
+	}
+	if restart.Window == 0 {
+		restart.Window = defaultRestartWindow
 	}
 }
 
+// Defaults for readiness polling, retries and supervision, in seconds.
+const (
+	defaultReadyTimeout  = 30
+	defaultPollInterval  = 1
+	defaultRetryDelay    = 1
+	defaultCheckInterval = 10
+	defaultRestartWindow = 300
+	defaultMaxRestarts   = 5
+)
+
+// Defaults for the audit trail: megabytes per file, files kept and bytes of
+// output per command.
+const (
+	defaultAuditMaxSize     = 10
+	defaultAuditMaxFiles    = 5
+	defaultAuditOutputLimit = 1024
+)
+
+func firstNonZero(values ...int) int {
+	for _, value := range values {
+		if value != 0 {
+			return value
+		}
+	}
+	return 0
+}
+
 func validateConfig(cfg *models.Config) error {
+	if cfg.Timeout < 0 {
+		return errors.New("negative timeout in config")
+	}
+	if cfg.ReadyTimeout < 0 || cfg.PollInterval < 0 || cfg.InitialDelay < 0 {
+		return errors.New("negative readiness setting in config")
+	}
+	if cfg.CheckInterval < 0 {
+		return errors.New("negative check_interval in config")
+	}
+	if cfg.Audit.MaxSize < 0 || cfg.Audit.MaxFiles < 0 || cfg.Audit.OutputLimit < 0 {
+		return errors.New("negative audit setting in config")
+	}
+	if strings.ContainsAny(cfg.Lock.Name, `/\`) {
+		return fmt.Errorf("lock name must not contain a path separator: %s", cfg.Lock.Name)
+	}
+	if err := ValidateFailurePolicy(cfg.FailurePolicy); err != nil {
+		return err
+	}
+
 	// Check for duplicate service names
 	serviceNames := make(map[string]bool)
 	for _, service := range cfg.Services {
+		if err := validateHooks(service.Hooks, false); err != nil {
+			return fmt.Errorf("%w in service: %s", err, service.Name)
+		}
 		if _, exists := serviceNames[service.Name]; exists {
 			return fmt.Errorf("duplicate service name: %s", service.Name)
 		}
 		serviceNames[service.Name] = true
 
+		if service.Timeout < 0 {
+			return fmt.Errorf("negative timeout in service: %s", service.Name)
+		}
+		if service.ReadyTimeout < 0 || service.PollInterval < 0 || service.InitialDelay < 0 {
+			return fmt.Errorf("negative readiness setting in service: %s", service.Name)
+		}
+
+		dependencyNames := make(map[string]bool)
+		for _, dependencyName := range service.DependsOn {
+			if dependencyNames[dependencyName] {
+				return fmt.Errorf("duplicate dependency: %s in service: %s", dependencyName, service.Name)
+			}
+			dependencyNames[dependencyName] = true
+		}
+
 		// Check for duplicate process names within a service
 		processNames := make(map[string]bool)
 		for _, process := range service.Processes {
+			if process.Timeout < 0 {
+				return fmt.Errorf("negative timeout in process: %s in service: %s", process.Name, service.Name)
+			}
+			if process.ReadyTimeout < 0 || process.PollInterval < 0 || process.InitialDelay < 0 || process.StopTimeout < 0 {
+				return fmt.Errorf("negative readiness setting in process: %s in service: %s", process.Name, service.Name)
+			}
 			if _, exists := processNames[process.Name]; exists {
 				return fmt.Errorf("duplicate process name: %s in service: %s", process.Name, service.Name)
 			}
 			processNames[process.Name] = true
+
+			if err := validateProcessCommands(process); err != nil {
+				return fmt.Errorf("%w in process: %s in service: %s", err, process.Name, service.Name)
+			}
+			if err := validateStatusPolicy(process.Status); err != nil {
+				return fmt.Errorf("%w in process: %s in service: %s", err, process.Name, service.Name)
+			}
+			if err := validateHealthCheck(process.Health); err != nil {
+				return fmt.Errorf("%w in process: %s in service: %s", err, process.Name, service.Name)
+			}
+			if err := validateRetryPolicy(process.Retry); err != nil {
+				return fmt.Errorf("%w in process: %s in service: %s", err, process.Name, service.Name)
+			}
+			if err := validateHooks(process.Hooks, process.Shell); err != nil {
+				return fmt.Errorf("%w in process: %s in service: %s", err, process.Name, service.Name)
+			}
+			if err := validateRestartPolicy(process.Restart); err != nil {
+				return fmt.Errorf("%w in process: %s in service: %s", err, process.Name, service.Name)
+			}
 		}
 	}
 	return nil
 }
 
-func createDependencyGraph(cfg *models.Config) (map[string][]string, error) {
-	graph := make(map[string][]string)
-	for _, service := range cfg.Services {
-		if _, exists := graph[service.Name]; !exists {
-			graph[service.Name] = []string{}
+func validateProcessCommands(process models.Process) error {
+	commands := []struct {
+		name string
+		line string
+		argv []string
+	}{
+		{"start", process.StartCmd, process.Argv.Start},
+		{"stop", process.StopCmd, process.Argv.Stop},
+		{"status", process.StatusCmd, process.Argv.Status},
+		{"kill", process.KillCmd, process.Argv.Kill},
+	}
+	for _, command := range commands {
+		if command.line != "" && len(command.argv) > 0 {
+			return fmt.Errorf("both %s_cmd and argv.%s are set", command.name, command.name)
 		}
-		if service.DependsOn != "" {
-			graph[service.DependsOn] = append(graph[service.DependsOn], service.Name)
+		if command.line != "" {
+			if _, err := executor.ParseCommand(command.line, process.Shell); err != nil {
+				return err
+			}
 		}
 	}
-	return graph, nil
+	return nil
 }
 
-func topologicalSort(graph map[string][]string, cfg *models.Config) ([]*models.Service, error) {
-	visited := make(map[string]bool)
-	includedInTree := make(map[string]bool)
-	var stack []*models.Service
+func validateStatusPolicy(policy *models.StatusPolicy) error {
+	if policy == nil {
+		return nil
+	}
+	for _, pattern := range []string{policy.StdoutMatch, policy.StdoutNotMatch, policy.StderrMatch, policy.StderrNotMatch, policy.DegradedMatch} {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid status pattern: %w", err)
+		}
+	}
+	if len(policy.JSONEquals) > 0 && policy.JSONField == "" {
+		return errors.New("status json_equals requires json_field")
+	}
+	return nil
+}
 
-	for node := range graph {
-		if !visited[node] {
-			if err := topologicalSortUtil(graph, node, visited, includedInTree, &stack, cfg); err != nil {
-				return nil, err
-			}
+func validateHealthCheck(health *models.HealthCheck) error {
+	if health == nil {
+		return nil
+	}
+	if health.HTTP != nil {
+		if health.HTTP.URL == "" {
+			return errors.New("health http check requires url")
+		}
+		if _, err := regexp.Compile(health.HTTP.BodyRegex); err != nil {
+			return fmt.Errorf("invalid health body_regex: %w", err)
+		}
+	}
+	if health.TCP != "" {
+		if _, _, err := net.SplitHostPort(health.TCP); err != nil {
+			return fmt.Errorf("invalid health tcp address: %w", err)
 		}
 	}
-	return stack, nil
+	if _, err := regexp.Compile(health.ProcessName); err != nil {
+		return fmt.Errorf("invalid health process_name: %w", err)
+	}
+	return nil
 }
 
-func topologicalSortUtil(graph map[string][]string, node string, visited, includedInTree map[string]bool, stack *[]*models.Service, cfg *models.Config) error {
-	visited[node] = true
+func validateRetryPolicy(retry *models.RetryPolicy) error {
+	if retry == nil {
+		return nil
+	}
+	switch retry.Backoff {
+	case "", models.BackoffFixed, models.BackoffExponential:
+	default:
+		return fmt.Errorf("unknown retry backoff: %s", retry.Backoff)
+	}
+	if retry.Attempts < 0 || retry.Delay < 0 || retry.MaxDelay < 0 {
+		return errors.New("negative retry setting")
+	}
+	if retry.Jitter < 0 || retry.Jitter > 1 {
+		return errors.New("retry jitter must be between 0 and 1")
+	}
+	return nil
+}
 
-	// Add the current node's dependents first
-	for _, dependentName := range graph[node] {
-		if !visited[dependentName] {
-			if err := topologicalSortUtil(graph, dependentName, visited, includedInTree, stack, cfg); err != nil {
-				return err
-			}
+func validateRestartPolicy(restart *models.RestartPolicy) error {
+	if restart == nil {
+		return nil
+	}
+	switch restart.Mode {
+	case "", models.RestartNever, models.RestartOnFailure, models.RestartAlways:
+	default:
+		return fmt.Errorf("unknown restart_policy mode: %s", restart.Mode)
+	}
+	switch restart.Backoff {
+	case "", models.BackoffFixed, models.BackoffExponential:
+	default:
+		return fmt.Errorf("unknown restart_policy backoff: %s", restart.Backoff)
+	}
+	if restart.MaxRestarts < 0 || restart.Window < 0 || restart.Delay < 0 || restart.MaxDelay < 0 {
+		return errors.New("negative restart_policy setting")
+	}
+	return nil
+}
+
+// validateHooks checks the hooks of a service or process; shell tells whether
+// the process runs its commands through a shell.
+func validateHooks(hooks models.Hooks, shell bool) error {
+	named := []struct {
+		name string
+		hook *models.Hook
+	}{
+		{"pre_start", hooks.PreStart},
+		{"post_start", hooks.PostStart},
+		{"pre_stop", hooks.PreStop},
+		{"post_stop", hooks.PostStop},
+	}
+	for _, h := range named {
+		if h.hook == nil {
+			continue
+		}
+		if h.hook.Cmd == "" {
+			return fmt.Errorf("%s hook requires cmd", h.name)
+		}
+		if h.hook.Timeout < 0 {
+			return fmt.Errorf("negative timeout in %s hook", h.name)
+		}
+		switch h.hook.OnFailure {
+		case "", models.HookAbort, models.HookWarn:
+		default:
+			return fmt.Errorf("unknown on_failure for %s hook: %s", h.name, h.hook.OnFailure)
+		}
+		if _, err := executor.ParseCommand(h.hook.Cmd, h.hook.Shell || shell); err != nil {
+			return fmt.Errorf("invalid %s hook: %w", h.name, err)
 		}
 	}
+	return nil
+}
 
-	// Only add to stack if not already included
-	if !includedInTree[node] {
-		for _, service := range cfg.Services {
-			if service.Name == node {
-				*stack = append(*stack, &service)
-				includedInTree[node] = true
-				break
+// createDependencyGraph maps each service name to the names of the services
+// that depend on it.
+func createDependencyGraph(cfg *models.Config) (map[string][]string, error) {
+	graph := make(map[string][]string)
+	for _, service := range cfg.Services {
+		graph[service.Name] = nil
+	}
+	for _, service := range cfg.Services {
+		for _, dependencyName := range service.DependsOn {
+			if _, exists := graph[dependencyName]; !exists {
+				return nil, fmt.Errorf("service %s depends on unknown service: %s", service.Name, dependencyName)
 			}
+			graph[dependencyName] = append(graph[dependencyName], service.Name)
 		}
 	}
+	return graph, nil
+}
+
+// topologicalSort orders services so that every service comes after all of
+// its dependencies, keeping config order where the graph allows. The graph
+// must be acyclic.
+func topologicalSort(graph map[string][]string, cfg *models.Config) []*models.Service {
+	pending := make(map[string]int)
+	for i := range cfg.Services {
+		pending[cfg.Services[i].Name] = len(cfg.Services[i].DependsOn)
+	}
 
-	return nil
+	// Repeatedly take the first service in config order whose dependencies
+	// have all been taken
+	var sorted []*models.Service
+	done := make(map[string]bool)
+	for len(sorted) < len(cfg.Services) {
+		for i := range cfg.Services {
+			service := &cfg.Services[i]
+			if done[service.Name] || pending[service.Name] > 0 {
+				continue
+			}
+			done[service.Name] = true
+			sorted = append(sorted, service)
+			for _, dependentName := range graph[service.Name] {
+				pending[dependentName]--
+			}
+			break
+		}
+	}
+	return sorted
 }
 
 func isCyclic(graph map[string][]string) bool {
@@ -178,10 +503,90 @@ func GetLeafNodes(services []*models.Service) []*models.Service {
 	return leafNodes
 }
 
+// Waves groups services into batches that can run one after another: every
+// service comes in a later wave than its prerequisites, and services in the
+// same wave don't depend on each other. Prerequisites outside services are
+// ignored.
+func Waves(services []*models.Service, prerequisites func(*models.Service) []*models.Service) [][]*models.Service {
+	included := make(map[*models.Service]bool)
+	for _, service := range services {
+		included[service] = true
+	}
+
+	levels := make(map[*models.Service]int)
+	var level func(*models.Service) int
+	level = func(service *models.Service) int {
+		if l, ok := levels[service]; ok {
+			return l
+		}
+		l := 0
+		for _, prerequisite := range prerequisites(service) {
+			if included[prerequisite] {
+				l = max(l, level(prerequisite)+1)
+			}
+		}
+		levels[service] = l
+		return l
+	}
+
+	var waves [][]*models.Service
+	for _, service := range services {
+		l := level(service)
+		for len(waves) <= l {
+			waves = append(waves, nil)
+		}
+		waves[l] = append(waves[l], service)
+	}
+	return waves
+}
+
+// Dependencies and Dependents return the links of a service, for use with
+// Waves.
+func Dependencies(service *models.Service) []*models.Service { return service.Dependencies }
+func Dependents(service *models.Service) []*models.Service   { return service.Dependents }
+
+// WithDependents returns service and everything that (transitively) depends
+// on it, in the order they appear in order.
+func WithDependents(order []*models.Service, service *models.Service) []*models.Service {
+	included := map[*models.Service]bool{service: true}
+	queue := []*models.Service{service}
+	for len(queue) > 0 {
+		for _, dependent := range queue[0].Dependents {
+			if !included[dependent] {
+				included[dependent] = true
+				queue = append(queue, dependent)
+			}
+		}
+		queue = queue[1:]
+	}
+
+	var services []*models.Service
+	for _, s := range order {
+		if included[s] {
+			services = append(services, s)
+		}
+	}
+	return services
+}
+
+// ParseBatchSize turns a batch size given as a count ("2") or a percentage
+// of total ("25%") into a count between 1 and total. Percentages round up.
+func ParseBatchSize(spec string, total int) (int, error) {
+	percent := strings.HasSuffix(spec, "%")
+	n, err := strconv.Atoi(strings.TrimSuffix(spec, "%"))
+	if err != nil || n <= 0 || (percent && n > 100) {
+		return 0, fmt.Errorf("invalid batch size: %s", spec)
+	}
+	if percent {
+		n = (total*n + 99) / 100
+	}
+	return max(1, min(n, total)), nil
+}
+
 func FindServiceByName(cfg *models.Config, serviceName string) (*models.Service, error) {
-	for _, service := range cfg.Services {
-		if service.Name == serviceName {
-			return &service, nil
+	for i := range cfg.Services {
+		if cfg.Services[i].Name == serviceName {
+			return &cfg.Services[i], nil
 		}
 	}
 	return nil, fmt.Errorf("service not found: %s", serviceName)
@@ -196,7 +601,9 @@ func FindProcessByName(service *models.Service, processName string) (*models.Pro
 	return nil, fmt.Errorf("process not found: %s in service: %s", processName, service.Name)
 }
 
-func PrintDependencyTree(services []*models.Service, prefix string, isLast bool) {
+// PrintDependencyTree writes the services and, below each, their dependents
+// to w.
+func PrintDependencyTree(w io.Writer, services []*models.Service, prefix string, isLast bool) {
 	for i, service := range services {
 		var branchSymbol string
 		if i == len(services)-1 {
@@ -205,7 +612,7 @@ func PrintDependencyTree(services []*models.Service, prefix string, isLast bool)
 			branchSymbol = "├───"
 		}
 
-		fmt.Printf("%s%s %s\n", prefix, branchSymbol, service.Name)
+		fmt.Fprintf(w, "%s%s %s\n", prefix, branchSymbol, service.Name)
 
 		newPrefix := prefix
 		if isLast {
@@ -214,6 +621,6 @@ func PrintDependencyTree(services []*models.Service, prefix string, isLast bool)
 			newPrefix += "│   "
 		}
 
-		PrintDependencyTree(service.Dependents, newPrefix, true)
+		PrintDependencyTree(w, service.Dependents, newPrefix, true)
 	}
 }
@@ -1 +1,474 @@
 package test
+
+import (
+	"big-brother/internal/app"
+	"big-brother/internal/executor"
+	"big-brother/internal/logger"
+	"big-brother/internal/models"
+	"big-brother/internal/scheduler"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// newFakeApp loads test_config.yaml with every command routed to a fake runner.
+func newFakeApp(t *testing.T) (*app.App, *executor.FakeRunner) {
+	t.Helper()
+	return newFakeAppWith(t, "test_config.yaml", false, false, "")
+}
+
+// newFakeAppWith is newTestApp with local commands going to the returned
+// fake runner.
+func newFakeAppWith(t *testing.T, configFile string, ignoreCheck, force bool, failurePolicy models.FailurePolicy) (*app.App, *executor.FakeRunner) {
+	t.Helper()
+
+	newApp := newTestApp(t, configFile, ignoreCheck, force, failurePolicy)
+	fake := executor.NewFakeRunner()
+	newApp.Executor.RegisterRunner(executor.RunnerLocal, fake)
+	return newApp, fake
+}
+
+// newTestApp loads configFile into an app that is closed with the test.
+func newTestApp(t *testing.T, configFile string, ignoreCheck, force bool, failurePolicy models.FailurePolicy) *app.App {
+	t.Helper()
+
+	newApp, err := app.NewApp(configFile, 1, ignoreCheck, force, failurePolicy, logger.NewLogger(false))
+	if err != nil {
+		t.Fatalf("NewApp failed: %v", err)
+	}
+	t.Cleanup(newApp.Close)
+	return newApp
+}
+
+// writeConfig writes config to name, which may include directories, in a
+// temporary directory of the test, and returns its path.
+func writeConfig(t *testing.T, name, config string) string {
+	t.Helper()
+
+	configFile := filepath.Join(t.TempDir(), name)
+	if err := os.MkdirAll(filepath.Dir(configFile), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(configFile, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return configFile
+}
+
+func TestApp_CheckServiceWithFakeRunner(t *testing.T) {
+	newApp, fake := newFakeApp(t)
+	fake.On("localhost", "echo 'checking process1 in service2'",
+		executor.FakeResponse{Output: ""},
+		executor.FakeResponse{Output: "running"})
+
+	results, err := newApp.CheckProcess(context.Background(), "service2", "process1")
+	if err != nil {
+		t.Fatalf("CheckProcess failed: %v", err)
+	}
+	if len(results) != 1 || results[0].IsRunning {
+		t.Errorf("Expected process1 to be stopped, got: %+v", results)
+	}
+
+	results, err = newApp.CheckProcess(context.Background(), "service2", "process1")
+	if err != nil {
+		t.Fatalf("CheckProcess failed: %v", err)
+	}
+	if len(results) != 1 || !results[0].IsRunning {
+		t.Errorf("Expected process1 to be running, got: %+v", results)
+	}
+}
+
+func TestApp_UnknownServiceReturnsError(t *testing.T) {
+	newApp, _ := newFakeApp(t)
+
+	if _, err := newApp.StartService(context.Background(), "missing"); err == nil {
+		t.Error("Expected an error starting an unknown service")
+	}
+	if _, err := newApp.CheckProcess(context.Background(), "service2", "missing"); err == nil {
+		t.Error("Expected an error checking an unknown process")
+	}
+}
+
+func TestApp_InvalidFailurePolicy(t *testing.T) {
+	if _, err := app.NewApp("test_config.yaml", 1, false, false, "sometimes", logger.NewLogger(false)); err == nil {
+		t.Error("Expected an error for an unknown failure policy")
+	}
+}
+
+func TestApp_StartAllRollsBackOnFailure(t *testing.T) {
+	newApp, fake := newFakeAppWith(t, "test_config.yaml", false, false, models.RollbackOnFailure)
+	fake.On("localhost", "echo 'starting process1 in service4'", executor.FakeResponse{ExitCode: 1})
+	for _, name := range []string{"process1", "process2"} {
+		// Stopped before the start, running after it, then stopped by the rollback
+		fake.On("localhost", "echo 'checking "+name+" in service2'",
+			executor.FakeResponse{Output: ""},
+			executor.FakeResponse{Output: "running"},
+			executor.FakeResponse{Output: "running"},
+			executor.FakeResponse{Output: ""})
+	}
+
+	results := newApp.StartAll(context.Background())
+
+	statuses := resultStatuses(results)
+	if statuses["service2"] != scheduler.StatusRolledBack {
+		t.Errorf("Expected service2 to be rolled back, got %s", statuses["service2"])
+	}
+	if statuses["service4"] != scheduler.StatusFailed {
+		t.Errorf("Expected service4 to fail, got %s", statuses["service4"])
+	}
+	for _, name := range []string{"service1", "service3", "service5", "service6", "service7"} {
+		if statuses[name] != scheduler.StatusSkipped {
+			t.Errorf("Expected %s to be skipped, got %s", name, statuses[name])
+		}
+	}
+
+	var stops []string
+	for _, call := range fake.Calls() {
+		if strings.HasPrefix(call.Command, "echo 'stopping") {
+			stops = append(stops, call.Command)
+		}
+	}
+	expected := []string{"echo 'stopping process1 in service2'", "echo 'stopping process2 in service2'"}
+	if !reflect.DeepEqual(stops, expected) {
+		t.Errorf("Expected stops %v, got %v", expected, stops)
+	}
+}
+
+// stopCalls lists the stop commands run by fake.
+func stopCalls(fake *executor.FakeRunner) []string {
+	var stops []string
+	for _, call := range fake.Calls() {
+		if strings.HasPrefix(call.Command, "echo 'stopping") {
+			stops = append(stops, call.Command)
+		}
+	}
+	return stops
+}
+
+func TestApp_RollbackStopsWhatTheRunStarted(t *testing.T) {
+	t.Run("partially started service", func(t *testing.T) {
+		newApp, fake := newFakeAppWith(t, "test_config.yaml", false, false, models.RollbackOnFailure)
+		// process1 starts, process2 fails to
+		fake.On("localhost", "echo 'checking process1 in service2'",
+			executor.FakeResponse{Output: ""},
+			executor.FakeResponse{Output: "running"},
+			executor.FakeResponse{Output: "running"},
+			executor.FakeResponse{Output: ""})
+		fake.On("localhost", "echo 'starting process2 in service2'", executor.FakeResponse{ExitCode: 1})
+
+		results := newApp.StartAll(context.Background())
+
+		for _, result := range results {
+			if result.Service.Name == "service2" && (result.Status != scheduler.StatusFailed || !reflect.DeepEqual(result.Notes, []string{"rolled back process1@localhost"})) {
+				t.Errorf("Expected service2 to fail with process1 rolled back, got %s %v", result.Status, result.Notes)
+			}
+		}
+		if stops, expected := stopCalls(fake), []string{"echo 'stopping process1 in service2'"}; !reflect.DeepEqual(stops, expected) {
+			t.Errorf("Expected stops %v, got %v", expected, stops)
+		}
+	})
+
+	t.Run("already running process", func(t *testing.T) {
+		newApp, fake := newFakeAppWith(t, "test_config.yaml", false, false, models.RollbackOnFailure)
+		fake.On("localhost", "echo 'starting process1 in service4'", executor.FakeResponse{ExitCode: 1})
+		fake.On("localhost", "echo 'checking process1 in service2'", executor.FakeResponse{Output: "running"})
+		fake.On("localhost", "echo 'checking process2 in service2'",
+			executor.FakeResponse{Output: ""},
+			executor.FakeResponse{Output: "running"},
+			executor.FakeResponse{Output: "running"},
+			executor.FakeResponse{Output: ""})
+
+		results := newApp.StartAll(context.Background())
+
+		if statuses := resultStatuses(results); statuses["service2"] != scheduler.StatusRolledBack {
+			t.Errorf("Expected service2 to be rolled back, got %s", statuses["service2"])
+		}
+		if stops, expected := stopCalls(fake), []string{"echo 'stopping process2 in service2'"}; !reflect.DeepEqual(stops, expected) {
+			t.Errorf("Expected stops %v, got %v", expected, stops)
+		}
+	})
+
+	t.Run("single service", func(t *testing.T) {
+		newApp, fake := newFakeAppWith(t, "test_config.yaml", false, false, models.RollbackOnFailure)
+		fake.On("localhost", "echo 'checking process1 in service2'",
+			executor.FakeResponse{Output: ""},
+			executor.FakeResponse{Output: "running"},
+			executor.FakeResponse{Output: "running"},
+			executor.FakeResponse{Output: ""})
+		fake.On("localhost", "echo 'starting process2 in service2'", executor.FakeResponse{ExitCode: 1})
+
+		results, err := newApp.StartService(context.Background(), "service2")
+		if err != nil {
+			t.Fatalf("StartService failed: %v", err)
+		}
+		if len(results) != 1 || !reflect.DeepEqual(results[0].Notes, []string{"rolled back process1@localhost"}) {
+			t.Errorf("Expected process1 to be rolled back, got %+v", results)
+		}
+		if stops, expected := stopCalls(fake), []string{"echo 'stopping process1 in service2'"}; !reflect.DeepEqual(stops, expected) {
+			t.Errorf("Expected stops %v, got %v", expected, stops)
+		}
+	})
+
+	t.Run("single process", func(t *testing.T) {
+		// Started, but never reports running
+		newApp, fake := newFakeAppWith(t, "test_config.yaml", false, true, models.RollbackOnFailure)
+
+		results, err := newApp.StartProcess(context.Background(), "service7", "process1")
+		if err != nil {
+			t.Fatalf("StartProcess failed: %v", err)
+		}
+		if len(results) != 1 || results[0].Status != scheduler.StatusFailed || !reflect.DeepEqual(results[0].Notes, []string{"rolled back process1@localhost"}) {
+			t.Errorf("Expected process1 to fail and be rolled back, got %+v", results)
+		}
+		if stops, expected := stopCalls(fake), []string{"echo 'stopping process1 in service7'"}; !reflect.DeepEqual(stops, expected) {
+			t.Errorf("Expected stops %v, got %v", expected, stops)
+		}
+	})
+
+	t.Run("restart", func(t *testing.T) {
+		newApp, fake := newFakeAppWith(t, "test_config.yaml", false, true, models.RollbackOnFailure)
+		fake.On("localhost", "echo 'checking process1 in service2'", executor.FakeResponse{Output: "running"})
+		fake.On("localhost", "echo 'checking process2 in service2'", executor.FakeResponse{Output: "running"})
+		// Stopped, started again and stopped by the rollback
+		for _, name := range []string{"service3", "service6"} {
+			fake.On("localhost", "echo 'checking process1 in "+name+"'",
+				executor.FakeResponse{Output: ""},
+				executor.FakeResponse{Output: "running"},
+				executor.FakeResponse{Output: ""})
+		}
+		fake.On("localhost", "echo 'starting process1 in service5'", executor.FakeResponse{ExitCode: 1})
+
+		results, err := newApp.RestartService(context.Background(), "service3")
+		if err != nil {
+			t.Fatalf("RestartService failed: %v", err)
+		}
+		statuses := resultStatuses(results)
+		if statuses["service3"] != scheduler.StatusRolledBack || statuses["service5"] != scheduler.StatusFailed {
+			t.Errorf("Expected service3 to be rolled back after service5 failed, got %v", statuses)
+		}
+		stops := 0
+		for _, stop := range stopCalls(fake) {
+			if stop == "echo 'stopping process1 in service3'" {
+				stops++
+			}
+		}
+		if stops != 2 {
+			t.Errorf("Expected service3 to be stopped by the restart and by the rollback, got %d stops", stops)
+		}
+	})
+}
+
+func TestApp_RestartServiceOrdersDependents(t *testing.T) {
+	newApp, fake := newFakeApp(t)
+	fake.On("localhost", "echo 'checking process1 in service2'", executor.FakeResponse{Output: "running"})
+	fake.On("localhost", "echo 'checking process2 in service2'", executor.FakeResponse{Output: "running"})
+	for _, name := range []string{"service3", "service5", "service6"} {
+		// Running before the stop, stopped after it and before the start, then running again
+		fake.On("localhost", "echo 'checking process1 in "+name+"'",
+			executor.FakeResponse{Output: "running"},
+			executor.FakeResponse{Output: ""},
+			executor.FakeResponse{Output: ""},
+			executor.FakeResponse{Output: "running"})
+	}
+
+	results, err := newApp.RestartService(context.Background(), "service3")
+	if err != nil {
+		t.Fatalf("RestartService failed: %v", err)
+	}
+	if failed := scheduler.Failed(results); len(failed) > 0 {
+		t.Fatalf("Unexpected failures: %+v", failed)
+	}
+
+	var actions []string
+	for _, call := range fake.Calls() {
+		if !strings.HasPrefix(call.Command, "echo 'checking") {
+			actions = append(actions, call.Command)
+		}
+	}
+	expected := []string{
+		"echo 'stopping process1 in service6'",
+		"echo 'stopping process1 in service5'",
+		"echo 'stopping process1 in service3'",
+		"echo 'starting process1 in service3'",
+		"echo 'starting process1 in service5'",
+		"echo 'starting process1 in service6'",
+	}
+	if !reflect.DeepEqual(actions, expected) {
+		t.Errorf("Expected actions %v, got %v", expected, actions)
+	}
+}
+
+func TestApp_RollingRestartAbortsAfterFailedBatch(t *testing.T) {
+	newApp, fake := newFakeApp(t)
+	fake.On("localhost", "echo 'starting process1 in service2'", executor.FakeResponse{ExitCode: 1})
+
+	results, err := newApp.RollingRestart(context.Background(), "service2", "1")
+	if err != nil {
+		t.Fatalf("RollingRestart failed: %v", err)
+	}
+	if len(results) != 2 || results[0].Status != scheduler.StatusFailed || results[1].Status != scheduler.StatusSkipped {
+		t.Fatalf("Expected the first batch to fail and the second to be skipped, got: %+v", results)
+	}
+	for _, call := range fake.Calls() {
+		if strings.Contains(call.Command, "process2") {
+			t.Errorf("Expected process2 to be left alone, got: %s", call.Command)
+		}
+	}
+}
+
+func TestApp_PlanRunsNothing(t *testing.T) {
+	newApp, fake := newFakeApp(t)
+
+	plan, err := newApp.Plan("restart", "service3", "")
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	if len(fake.Calls()) != 0 {
+		t.Errorf("Expected no commands to run, got: %+v", fake.Calls())
+	}
+
+	if plan.Concurrency != 1 {
+		t.Errorf("Expected the plan to handle one service at a time, got %d", plan.Concurrency)
+	}
+	var levels [][]string
+	for _, phase := range plan.Phases {
+		for _, level := range phase.Levels {
+			var names []string
+			for _, service := range level {
+				names = append(names, phase.Action+" "+service.Name)
+			}
+			levels = append(levels, names)
+		}
+	}
+	expected := [][]string{
+		{"stop service5", "stop service6"},
+		{"stop service3"},
+		{"start service3"},
+		{"start service5", "start service6"},
+	}
+	for _, level := range levels {
+		sort.Strings(level)
+	}
+	if !reflect.DeepEqual(levels, expected) {
+		t.Errorf("Expected levels %v, got %v", expected, levels)
+	}
+
+	step := plan.Phases[1].Levels[0][0].Steps[0]
+	if step.Host != "localhost" || step.Runner != executor.RunnerLocal || step.Command != "echo 'starting process1 in service3'" {
+		t.Errorf("Unexpected step: %+v", step)
+	}
+}
+
+func TestApp_StartSkipsRunningProcesses(t *testing.T) {
+	newApp, fake := newFakeApp(t)
+	fake.On("localhost", "echo 'checking process1 in service7'", executor.FakeResponse{Output: "running"})
+
+	results, err := newApp.StartService(context.Background(), "service7")
+	if err != nil {
+		t.Fatalf("StartService failed: %v", err)
+	}
+	if len(results) != 1 || results[0].Status != scheduler.StatusAlreadyRunning {
+		t.Fatalf("Expected service7 to be already running, got: %+v", results)
+	}
+	for _, call := range fake.Calls() {
+		if strings.HasPrefix(call.Command, "echo 'starting") {
+			t.Errorf("Expected no start command, got: %s", call.Command)
+		}
+	}
+}
+
+func TestApp_StopWithForceRunsStoppedProcesses(t *testing.T) {
+	newApp, fake := newFakeAppWith(t, "test_config.yaml", false, true, "")
+
+	results, err := newApp.StopProcess(context.Background(), "service7", "process1")
+	if err != nil {
+		t.Fatalf("StopProcess failed: %v", err)
+	}
+	if len(results) != 1 || results[0].Status != scheduler.StatusSucceeded {
+		t.Fatalf("Expected service7 to be stopped, got: %+v", results)
+	}
+	expected := []string{"echo 'stopping process1 in service7'", "echo 'checking process1 in service7'"}
+	if calls := serviceCalls(fake, "service7"); !reflect.DeepEqual(calls, expected) {
+		t.Errorf("Expected the stop command and the readiness check only, got: %v", calls)
+	}
+}
+
+func TestApp_StartServiceWithLocalCommands(t *testing.T) {
+	newApp := newTestApp(t, "test_config.yaml", false, true, "")
+
+	results, err := newApp.StartService(context.Background(), "service2")
+	if err != nil {
+		t.Fatalf("StartService failed: %v", err)
+	}
+	if failed := scheduler.Failed(results); len(failed) > 0 {
+		t.Errorf("Unexpected failures: %+v", failed)
+	}
+}
+
+// serviceCalls returns the commands run for service, in order.
+func serviceCalls(fake *executor.FakeRunner, service string) []string {
+	var calls []string
+	for _, call := range fake.Calls() {
+		if strings.HasSuffix(call.Command, " in "+service+"'") {
+			calls = append(calls, call.Command)
+		}
+	}
+	return calls
+}
+
+// scriptStateChange makes every status command of test_config.yaml report
+// from, then to once checked again.
+func scriptStateChange(fake *executor.FakeRunner, from, to string) {
+	for _, service := range []string{"service1", "service2", "service3", "service4", "service5", "service6", "service7"} {
+		for _, process := range []string{"process1", "process2"} {
+			fake.On("localhost", "echo 'checking "+process+" in "+service+"'",
+				executor.FakeResponse{Output: from},
+				executor.FakeResponse{Output: to})
+		}
+	}
+}
+
+func TestApp_SingleServiceMatchesFullRun(t *testing.T) {
+	for _, command := range []string{"start", "stop"} {
+		from, to := "", "running"
+		if command == "stop" {
+			from, to = to, from
+		}
+
+		allApp, allFake := newFakeAppWith(t, "test_config.yaml", true, false, "")
+		scriptStateChange(allFake, from, to)
+		var allResults []scheduler.Result
+		if command == "start" {
+			allResults = allApp.StartAll(context.Background())
+		} else {
+			allResults = allApp.StopAll(context.Background())
+		}
+
+		for _, all := range allResults {
+			name := all.Service.Name
+			oneApp, oneFake := newFakeAppWith(t, "test_config.yaml", true, false, "")
+			scriptStateChange(oneFake, from, to)
+			var oneResults []scheduler.Result
+			var err error
+			if command == "start" {
+				oneResults, err = oneApp.StartService(context.Background(), name)
+			} else {
+				oneResults, err = oneApp.StopService(context.Background(), name)
+			}
+			if err != nil {
+				t.Fatalf("%s -s %s failed: %v", command, name, err)
+			}
+
+			if len(oneResults) != 1 || oneResults[0].Status != all.Status {
+				t.Errorf("%s -s %s: expected status %s, got %+v", command, name, all.Status, oneResults)
+			}
+			if one, all := serviceCalls(oneFake, name), serviceCalls(allFake, name); !reflect.DeepEqual(one, all) {
+				t.Errorf("%s -s %s ran %v, %s of everything ran %v", command, name, one, command, all)
+			}
+		}
+	}
+}
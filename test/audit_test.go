@@ -0,0 +1,104 @@
+package test
+
+import (
+	"big-brother/internal/audit"
+	"big-brother/internal/executor"
+	"big-brother/internal/logger"
+	"big-brother/internal/models"
+	"big-brother/internal/rotate"
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readAuditEntries(t *testing.T, path string) []audit.Entry {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var entries []audit.Entry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry audit.Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("Invalid audit line %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestAudit_ExecutorRecordsCommands(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit", "audit.jsonl")
+	auditLog, err := audit.New(models.AuditConfig{File: path, MaxSize: 10, MaxFiles: 1, OutputLimit: 5}, "alice", []string{"big-brother", "start"})
+	if err != nil {
+		t.Fatalf("audit.New failed: %v", err)
+	}
+	defer auditLog.Close()
+
+	newExecutor := executor.NewExecutor(logger.NewLogger(false))
+	newExecutor.SetAudit(auditLog)
+	ctx := executor.WithLabels(context.Background(), executor.Labels{RunID: "run1", Service: "service1"})
+	process := &models.Process{Name: "process1", HostName: "localhost", StartCmd: "echo started-process1", StatusCmd: "echo up"}
+
+	if _, err := newExecutor.RunProcessAction(ctx, process, executor.ActionStart); err != nil {
+		t.Fatalf("RunProcessAction failed: %v", err)
+	}
+	if _, err := newExecutor.RunProcessAction(ctx, process, executor.ActionStatus); err != nil {
+		t.Fatalf("RunProcessAction failed: %v", err)
+	}
+	newExecutor.ExecuteCommand(context.Background(), "false", "localhost")
+
+	entries := readAuditEntries(t, path)
+	if len(entries) != 2 {
+		t.Fatalf("Expected the start command and false to be audited, got %+v", entries)
+	}
+	start := entries[0]
+	if start.User != "alice" || start.CommandLine != "big-brother start" || start.RunID != "run1" ||
+		start.Service != "service1" || start.Process != "process1" || start.Action != "start" ||
+		start.Host != "localhost" || start.Runner != "local" || start.Command != "echo started-process1" {
+		t.Errorf("Unexpected entry: %+v", start)
+	}
+	if !strings.HasPrefix(start.Output, "start") || !strings.HasSuffix(start.Output, "(truncated)") {
+		t.Errorf("Expected truncated output, got %q", start.Output)
+	}
+	if entries[1].Command != "false" || entries[1].ExitCode != 1 {
+		t.Errorf("Expected the failed command with its exit code, got %+v", entries[1])
+	}
+}
+
+func TestRotate_FileRotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	file, err := rotate.Open(path, 100, 2)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer file.Close()
+
+	line := []byte(`{"command":"` + strings.Repeat("x", 60) + `"}` + "\n")
+	for i := 0; i < 4; i++ {
+		if _, err := file.Write(line); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatalf("Expected %s to exist: %v", name, err)
+		}
+		if string(data) != string(line) {
+			t.Errorf("Expected one line in %s, got %q", name, data)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected only 2 rotated files to be kept")
+	}
+}
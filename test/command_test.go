@@ -0,0 +1,63 @@
+package test
+
+import (
+	"big-brother/internal/executor"
+	"reflect"
+	"testing"
+)
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		line string
+		argv []string
+		env  []string
+	}{
+		{"echo hello", []string{"echo", "hello"}, nil},
+		{"echo 'starting process1'", []string{"echo", "starting process1"}, nil},
+		{`ls "/opt/my app/bin" it\'s`, []string{"ls", "/opt/my app/bin", "it's"}, nil},
+		{`echo "say \"hi\""`, []string{"echo", `say "hi"`}, nil},
+		{"JAVA_OPTS='-Xmx1g -Xms1g' ./run.sh start", []string{"./run.sh", "start"}, []string{"JAVA_OPTS=-Xmx1g -Xms1g"}},
+		{"echo A=b", []string{"echo", "A=b"}, nil},
+		{"'A=b' echo", []string{"A=b", "echo"}, nil},
+	}
+
+	for _, test := range tests {
+		cmd, err := executor.ParseCommand(test.line, false)
+		if err != nil {
+			t.Errorf("ParseCommand(%q) failed: %v", test.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(cmd.Argv, test.argv) || !reflect.DeepEqual(cmd.Env, test.env) {
+			t.Errorf("ParseCommand(%q) = argv %q env %q, expected argv %q env %q", test.line, cmd.Argv, cmd.Env, test.argv, test.env)
+		}
+	}
+
+	// Shell syntax requires shell: true
+	for _, line := range []string{"pgrep java | head -1", "start.sh && sleep 1", "echo $HOME", "run > out.log", `echo "$(date)"`} {
+		if _, err := executor.ParseCommand(line, false); err == nil {
+			t.Errorf("ParseCommand(%q) should have failed without shell", line)
+		}
+		cmd, err := executor.ParseCommand(line, true)
+		if err != nil || cmd.String() != line {
+			t.Errorf("ParseCommand(%q, shell) = %q, %v", line, cmd.String(), err)
+		}
+	}
+
+	if _, err := executor.ParseCommand("echo 'unterminated", false); err == nil {
+		t.Error("ParseCommand should have failed for an unterminated quote")
+	}
+}
+
+func TestCommandString(t *testing.T) {
+	cmd := executor.Command{Argv: []string{"echo", "it's here", "plain"}, Env: []string{"A=b c"}}
+	expected := `A='b c' echo 'it'\''s here' plain`
+	if cmd.String() != expected {
+		t.Errorf("Expected %s, got %s", expected, cmd.String())
+	}
+
+	// Round trip through the parser
+	parsed, err := executor.ParseCommand(cmd.String(), false)
+	if err != nil || !reflect.DeepEqual(parsed, cmd) {
+		t.Errorf("Round trip failed: %+v, %v", parsed, err)
+	}
+}
@@ -3,6 +3,9 @@ package test
 import (
 	"big-brother/internal/config"
 	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -21,6 +24,9 @@ func TestLoadConfig(t *testing.T) {
 	if len(cfg.Services) != 7 {
 		t.Errorf("Expected 2 services, but got %d", len(cfg.Services))
 	}
+	if deps := cfg.Services[0].DependsOn; len(deps) != 1 || deps[0] != "service4" {
+		t.Errorf("Expected service1 to depend on [service4], but got %v", deps)
+	}
 	//TODO: add more assertions
 
 	// Test loading an invalid config file
@@ -29,3 +35,32 @@ func TestLoadConfig(t *testing.T) {
 		t.Error("LoadConfig should have failed for invalid config")
 	}
 }
+
+func TestLoadConfig_DependsOnList(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	data := `services:
+  - name: app
+    depends_on: [db, cache]
+  - name: web
+    depends_on: app
+  - name: db
+  - name: cache
+    depends_on:
+      - db
+`
+	if err := os.WriteFile(configFile, []byte(data), 0644); err != nil {
+		t.Fatalf("error writing config: %v", err)
+	}
+
+	cfg, err := config.LoadConfig(configFile)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	expected := map[string][]string{"app": {"db", "cache"}, "web": {"app"}, "db": nil, "cache": {"db"}}
+	for _, service := range cfg.Services {
+		if !reflect.DeepEqual([]string(service.DependsOn), expected[service.Name]) {
+			t.Errorf("Service %s: expected depends_on %v, got %v", service.Name, expected[service.Name], service.DependsOn)
+		}
+	}
+}
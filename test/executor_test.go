@@ -4,15 +4,17 @@ import (
 	"big-brother/internal/executor"
 	"big-brother/internal/logger"
 	"big-brother/internal/models"
+	"context"
+	"strings"
 	"testing"
 )
 
 func TestExecutor_ExecuteCommand(t *testing.T) {
 	log := logger.NewLogger(false) // Initialize the actual logger
-	newExecutor := executor.NewExecutor(log, 1)
+	newExecutor := executor.NewExecutor(log)
 
 	// Test executing a valid command (assuming 'echo' exists)
-	output, err := newExecutor.ExecuteCommand("echo hello", "localhost")
+	output, err := newExecutor.ExecuteCommand(context.Background(), "echo hello", "localhost")
 	if err != nil {
 		t.Errorf("ExecuteCommand failed for valid command: %v", err)
 	}
@@ -21,34 +23,71 @@ func TestExecutor_ExecuteCommand(t *testing.T) {
 	}
 
 	// Test executing an invalid command
-	_, err = newExecutor.ExecuteCommand("invalid_command", "localhost")
+	_, err = newExecutor.ExecuteCommand(context.Background(), "invalid_command", "localhost")
 	if err == nil {
 		t.Error("ExecuteCommand should have failed for invalid command")
 	}
 }
 
-func TestExecutor_StartService(t *testing.T) {
-	log := logger.NewLogger(false) // Initialize the actual logger
-	newExecutor := executor.NewExecutor(log, 1)
-
-	service := &models.Service{
-		Name: "test_service",
-		Processes: []models.Process{
-			{
-				Name:      "process1",
-				HostName:  "localhost",
-				StartCmd:  "echo 'starting process1'",
-				StopCmd:   "echo 'stopping process1'",
-				StatusCmd: "echo 'All Good!'",
-			},
-		},
-	}
-
-	err := newExecutor.StartService(service)
+func TestExecutor_RunnerSelection(t *testing.T) {
+	newExecutor := executor.NewExecutor(logger.NewLogger(false))
+	newExecutor.ConfigureHosts(models.SSHConfig{}, []models.Host{{Name: "box1", Runner: "fake"}})
+	fake := executor.NewFakeRunner()
+	newExecutor.RegisterRunner("fake", fake)
+	fake.On("box1", "status", executor.FakeResponse{Output: "up"})
+
+	// Host level runner
+	output, err := newExecutor.ExecuteCommand(context.Background(), "status", "box1")
 	if err != nil {
-		t.Errorf("StartService failed: %v", err)
+		t.Fatalf("ExecuteCommand failed: %v", err)
+	}
+	if output != "up" {
+		t.Errorf("Unexpected output: %s", output)
+	}
+
+	// Process level runner overrides the local default for localhost
+	process := &models.Process{Name: "process1", HostName: "localhost", Runner: "fake", StatusCmd: "status"}
+	if _, err := newExecutor.ExecuteProcessCommand(context.Background(), process, process.StatusCmd); err != nil {
+		t.Fatalf("ExecuteProcessCommand failed: %v", err)
 	}
 
-	// Optionally, add assertions to verify the process status
-	// (though this may require more complex setup to verify running processes)
+	calls := fake.Calls()
+	if len(calls) != 2 || calls[1].HostName != "localhost" {
+		t.Errorf("Unexpected calls: %+v", calls)
+	}
+
+	// Unknown runners are reported
+	process.Runner = "missing"
+	if _, err := newExecutor.ExecuteProcessCommand(context.Background(), process, process.StatusCmd); err == nil {
+		t.Error("ExecuteProcessCommand should have failed for an unknown runner")
+	}
+}
+
+func TestExecutor_ShellAndArgv(t *testing.T) {
+	log := logger.NewLogger(false)
+	newExecutor := executor.NewExecutor(log)
+
+	process := &models.Process{
+		Name:      "process1",
+		HostName:  "localhost",
+		Shell:     true,
+		StatusCmd: "echo one two | wc -w",
+		Argv:      models.ProcessArgv{Start: []string{"echo", "a  b"}},
+	}
+
+	output, err := newExecutor.RunProcessAction(context.Background(), process, executor.ActionStatus)
+	if err != nil {
+		t.Fatalf("RunProcessAction failed for shell status: %v", err)
+	}
+	if strings.TrimSpace(output) != "2" {
+		t.Errorf("Unexpected shell output: %q", output)
+	}
+
+	output, err = newExecutor.RunProcessAction(context.Background(), process, executor.ActionStart)
+	if err != nil {
+		t.Fatalf("RunProcessAction failed for argv start: %v", err)
+	}
+	if output != "a  b\n" {
+		t.Errorf("Unexpected argv output: %q", output)
+	}
 }
@@ -0,0 +1,128 @@
+package test
+
+import (
+	"big-brother/internal/executor"
+	"big-brother/internal/logger"
+	"big-brother/internal/models"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func checkHealth(t *testing.T, newExecutor *executor.Executor, health *models.HealthCheck) models.ProcessState {
+	t.Helper()
+
+	process := &models.Process{Name: "process1", HostName: "localhost", Timeout: 1, Health: health}
+	state, err := newExecutor.CheckProcess(context.Background(), process)
+	if err != nil && state != models.StateTimedOut {
+		t.Fatalf("CheckProcess failed: %v", err)
+	}
+	return state
+}
+
+func TestHealth_HTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/health":
+			w.Write([]byte(`{"status":"UP"}`))
+		case "/slow":
+			time.Sleep(3 * time.Second)
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	newExecutor := executor.NewExecutor(logger.NewLogger(false))
+
+	tests := []struct {
+		check    models.HTTPCheck
+		expected models.ProcessState
+	}{
+		{models.HTTPCheck{URL: server.URL + "/health"}, models.StateRunning},
+		{models.HTTPCheck{URL: server.URL + "/health", BodyRegex: `"UP"`}, models.StateRunning},
+		{models.HTTPCheck{URL: server.URL + "/health", BodyRegex: `"DOWN"`}, models.StateStopped},
+		{models.HTTPCheck{URL: server.URL + "/down"}, models.StateStopped},
+		{models.HTTPCheck{URL: server.URL + "/down", ExpectStatus: http.StatusServiceUnavailable}, models.StateRunning},
+		{models.HTTPCheck{URL: server.URL + "/slow"}, models.StateTimedOut},
+	}
+	for _, test := range tests {
+		check := test.check
+		if state := checkHealth(t, newExecutor, &models.HealthCheck{HTTP: &check}); state != test.expected {
+			t.Errorf("HTTP check %+v: expected %s, got %s", test.check, test.expected, state)
+		}
+	}
+}
+
+func TestHealth_TCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	address := listener.Addr().String()
+	newExecutor := executor.NewExecutor(logger.NewLogger(false))
+
+	if state := checkHealth(t, newExecutor, &models.HealthCheck{TCP: address}); state != models.StateRunning {
+		t.Errorf("Expected running with a listener, got %s", state)
+	}
+
+	listener.Close()
+	if state := checkHealth(t, newExecutor, &models.HealthCheck{TCP: address}); state != models.StateStopped {
+		t.Errorf("Expected stopped without a listener, got %s", state)
+	}
+}
+
+func TestHealth_PIDFileAndProcessName(t *testing.T) {
+	newExecutor := executor.NewExecutor(logger.NewLogger(false))
+	dir := t.TempDir()
+
+	pidFile := filepath.Join(dir, "app.pid")
+	os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getppid())+"\n"), 0644)
+	if state := checkHealth(t, newExecutor, &models.HealthCheck{PIDFile: pidFile}); state != models.StateRunning {
+		t.Errorf("Expected running for a live pid, got %s", state)
+	}
+	if state := checkHealth(t, newExecutor, &models.HealthCheck{PIDFile: filepath.Join(dir, "missing.pid")}); state != models.StateStopped {
+		t.Errorf("Expected stopped for a missing pid file, got %s", state)
+	}
+
+	// A process that is certain to exist while the test runs
+	sleeper := filepath.Join(dir, "bb-health-sleeper")
+	os.Symlink("/bin/sleep", sleeper)
+	cmd := exec.Command(sleeper, "30")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("error starting sleeper: %v", err)
+	}
+	defer cmd.Process.Kill()
+
+	pattern := regexp.QuoteMeta(sleeper) + " 30"
+	if state := checkHealth(t, newExecutor, &models.HealthCheck{ProcessName: pattern}); state != models.StateRunning {
+		t.Errorf("Expected running for a matching process, got %s", state)
+	}
+	if state := checkHealth(t, newExecutor, &models.HealthCheck{ProcessName: "no-such-process-[0-9]{12}"}); state != models.StateStopped {
+		t.Errorf("Expected stopped for no matching process, got %s", state)
+	}
+}
+
+func TestHealth_RemoteProbesUseRunner(t *testing.T) {
+	newExecutor := executor.NewExecutor(logger.NewLogger(false))
+	fake := executor.NewFakeRunner()
+	newExecutor.RegisterRunner(executor.RunnerSSH, fake)
+	fake.On("remote1", "pgrep -f 'java.*app'", executor.FakeResponse{ExitCode: 1})
+
+	process := &models.Process{Name: "process1", HostName: "remote1", Health: &models.HealthCheck{ProcessName: "java.*app"}}
+	state, err := newExecutor.CheckProcess(context.Background(), process)
+	if err != nil || state != models.StateStopped {
+		t.Errorf("Expected stopped from remote pgrep, got %s, %v", state, err)
+	}
+	if calls := fake.Calls(); len(calls) != 1 || calls[0].HostName != "remote1" {
+		t.Errorf("Unexpected calls: %+v", calls)
+	}
+}
@@ -0,0 +1,113 @@
+package test
+
+import (
+	"big-brother/internal/models"
+	"big-brother/internal/state"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStateStore_ListFilters(t *testing.T) {
+	store, err := state.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	now := time.Now()
+	runs := []*models.Run{
+		{Command: "start", Started: now.Add(-48 * time.Hour), Results: []models.RunResult{{Name: "service1"}}},
+		{Command: "stop", Service: "service2", Started: now.Add(-time.Hour)},
+		{Command: "restart", Started: now, Commands: []models.CommandRecord{{Service: "service1"}}},
+	}
+	for _, run := range runs {
+		run.ID = state.NewRunID(run.Started)
+		if err := store.Save(run); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+
+	commands := func(filter state.Filter) []string {
+		t.Helper()
+		found, err := store.List(filter)
+		if err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		var names []string
+		for _, run := range found {
+			names = append(names, run.Command)
+		}
+		return names
+	}
+
+	if got := commands(state.Filter{}); len(got) != 3 || got[0] != "restart" {
+		t.Errorf("Expected every run, newest first, got %v", got)
+	}
+	if got := commands(state.Filter{Service: "service1"}); len(got) != 2 || got[0] != "restart" || got[1] != "start" {
+		t.Errorf("Expected the runs touching service1, got %v", got)
+	}
+	if got := commands(state.Filter{Since: now.Add(-2 * time.Hour), Until: now.Add(-time.Minute)}); len(got) != 1 || got[0] != "stop" {
+		t.Errorf("Expected the run within the time range, got %v", got)
+	}
+	if got := commands(state.Filter{Limit: 1}); len(got) != 1 {
+		t.Errorf("Expected one run, got %v", got)
+	}
+
+	if _, err := store.Load("missing"); err == nil {
+		t.Error("Expected an error loading an unknown run")
+	}
+}
+
+func TestApp_RecordsRuns(t *testing.T) {
+	newApp, fake := newFakeApp(t)
+	store, err := newApp.OpenStateStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("OpenStateStore failed: %v", err)
+	}
+	scriptStateChange(fake, "", "running")
+
+	if _, err := newApp.StartProcess(context.Background(), "service2", "process1"); err != nil {
+		t.Fatalf("StartProcess failed: %v", err)
+	}
+	if _, err := newApp.StartService(context.Background(), "missing"); err == nil {
+		t.Fatal("Expected an error starting an unknown service")
+	}
+
+	runs, err := store.List(state.Filter{})
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(runs) != 2 {
+		t.Fatalf("Expected 2 runs, got %d", len(runs))
+	}
+	if failed := runs[0]; failed.Status != "failed" || failed.Error == "" {
+		t.Errorf("Expected the unknown service run to fail, got %+v", failed)
+	}
+
+	run := runs[1]
+	if run.Command != "start" || run.Service != "service2" || run.Process != "process1" || run.Status != "succeeded" {
+		t.Errorf("Unexpected run: %+v", run)
+	}
+	if len(run.Results) != 1 || run.Results[0].Name != "service2" {
+		t.Errorf("Unexpected results: %+v", run.Results)
+	}
+	var started bool
+	for _, command := range run.Commands {
+		if command.Action == "start" {
+			started = command.Service == "service2" && command.Process == "process1" && command.Host == "localhost" && command.Command == "echo 'starting process1 in service2'"
+			if want := filepath.Join(store.OutputDir(run.ID), "001-service2-process1-start.log"); command.OutputFile != want {
+				t.Errorf("Expected the start output in %s, got %q", want, command.OutputFile)
+			}
+			if _, err := os.Stat(command.OutputFile); err != nil {
+				t.Errorf("Expected the output file to exist: %v", err)
+			}
+		} else if command.OutputFile != "" {
+			t.Errorf("Expected no output file for %+v", command)
+		}
+	}
+	if !started {
+		t.Errorf("Expected the start command to be recorded, got %+v", run.Commands)
+	}
+}
@@ -0,0 +1,158 @@
+package test
+
+import (
+	"big-brother/internal/executor"
+	"big-brother/internal/models"
+	"big-brother/internal/scheduler"
+	"big-brother/internal/utils"
+	"context"
+	"reflect"
+	"testing"
+)
+
+const hooksConfig = `services:
+  - name: web
+    pre_start: "lb enable-maintenance"
+    post_stop:
+      cmd: "lb notify"
+      on_failure: warn
+    processes:
+      - name: app
+        host_name: localhost
+        start_cmd: "app start"
+        stop_cmd: "app stop"
+        status_cmd: "app status"
+        pre_stop: "lb drain app"
+        post_start:
+          cmd: "cache warm"
+          timeout: 5
+`
+
+// actionCalls lists the commands run by fake, leaving out status checks.
+func actionCalls(fake *executor.FakeRunner) []string {
+	var calls []string
+	for _, call := range fake.Calls() {
+		if call.Command != "app status" {
+			calls = append(calls, call.Command)
+		}
+	}
+	return calls
+}
+
+func TestApp_HooksRunAroundStartAndStop(t *testing.T) {
+	newApp, fake := newFakeAppWith(t, writeConfig(t, "config.yaml", hooksConfig), false, false, "")
+	fake.On("localhost", "app status",
+		executor.FakeResponse{Output: ""},
+		executor.FakeResponse{Output: "running"},
+		executor.FakeResponse{Output: "running"},
+		executor.FakeResponse{Output: ""})
+	fake.On("localhost", "lb notify", executor.FakeResponse{ExitCode: 1})
+
+	if _, err := newApp.StartService(context.Background(), "web"); err != nil {
+		t.Fatalf("StartService failed: %v", err)
+	}
+	results, err := newApp.StopService(context.Background(), "web")
+	if err != nil {
+		t.Fatalf("StopService failed: %v", err)
+	}
+
+	// A failing warn hook is noted but does not fail the stop
+	if len(results) != 1 || results[0].Status != scheduler.StatusSucceeded {
+		t.Fatalf("Expected web to stop, got: %+v", results)
+	}
+	if expected := []string{"post_stop hook of service web failed"}; !reflect.DeepEqual(results[0].Notes, expected) {
+		t.Errorf("Expected notes %v, got %v", expected, results[0].Notes)
+	}
+
+	expected := []string{"lb enable-maintenance", "app start", "cache warm", "lb drain app", "app stop", "lb notify"}
+	if calls := actionCalls(fake); !reflect.DeepEqual(calls, expected) {
+		t.Errorf("Expected calls %v, got %v", expected, calls)
+	}
+}
+
+func TestApp_HooksRunDuringRollingRestart(t *testing.T) {
+	newApp, fake := newFakeAppWith(t, writeConfig(t, "config.yaml", hooksConfig), false, false, "")
+	fake.On("localhost", "app status",
+		executor.FakeResponse{Output: ""},
+		executor.FakeResponse{Output: "running"})
+
+	results, err := newApp.RollingRestart(context.Background(), "web", "1")
+	if err != nil {
+		t.Fatalf("RollingRestart failed: %v", err)
+	}
+	if len(results) != 1 || results[0].Status != scheduler.StatusSucceeded {
+		t.Fatalf("Expected the batch to succeed, got: %+v", results)
+	}
+
+	expected := []string{"lb drain app", "app stop", "lb notify", "lb enable-maintenance", "app start", "cache warm"}
+	if calls := actionCalls(fake); !reflect.DeepEqual(calls, expected) {
+		t.Errorf("Expected calls %v, got %v", expected, calls)
+	}
+}
+
+func TestApp_FailingHookAbortsStart(t *testing.T) {
+	newApp, fake := newFakeAppWith(t, writeConfig(t, "config.yaml", hooksConfig), false, false, "")
+	fake.On("localhost", "app status", executor.FakeResponse{Output: ""})
+	fake.On("localhost", "lb enable-maintenance", executor.FakeResponse{ExitCode: 1})
+
+	results, err := newApp.StartService(context.Background(), "web")
+	if err != nil {
+		t.Fatalf("StartService failed: %v", err)
+	}
+	if len(results) != 1 || results[0].Status != scheduler.StatusFailed {
+		t.Fatalf("Expected web to fail, got: %+v", results)
+	}
+	if calls := actionCalls(fake); !reflect.DeepEqual(calls, []string{"lb enable-maintenance"}) {
+		t.Errorf("Expected only the pre_start hook to run, got %v", calls)
+	}
+}
+
+func TestApp_PlanIncludesHooks(t *testing.T) {
+	newApp, _ := newFakeAppWith(t, writeConfig(t, "config.yaml", hooksConfig), false, false, "")
+
+	plan, err := newApp.Plan("start", "web", "")
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	var steps []string
+	for _, step := range plan.Phases[0].Levels[0][0].Steps {
+		steps = append(steps, step.Hook+":"+step.Command)
+	}
+	expected := []string{"pre_start:lb enable-maintenance", ":app start", "post_start:cache warm"}
+	if !reflect.DeepEqual(steps, expected) {
+		t.Errorf("Expected steps %v, got %v", expected, steps)
+	}
+}
+
+func TestValidateConfig_HookDefaults(t *testing.T) {
+	cfg := &models.Config{
+		Timeout: 30,
+		Services: []models.Service{{
+			Name:  "service1",
+			Hooks: models.Hooks{PostStop: &models.Hook{Cmd: "notify"}},
+			Processes: []models.Process{{
+				Name:     "process1",
+				HostName: "host1",
+				Timeout:  10,
+				Hooks:    models.Hooks{PreStop: &models.Hook{Cmd: "drain", OnFailure: models.HookWarn}},
+			}},
+		}},
+	}
+	if err := utils.ValidateConfigAndBuildDependencyTree(cfg); err != nil {
+		t.Fatalf("ValidateConfigAndBuildDependencyTree failed: %v", err)
+	}
+
+	expected := models.Hook{Cmd: "notify", HostName: "localhost", Timeout: 30, OnFailure: models.HookAbort}
+	if hook := *cfg.Services[0].Hooks.PostStop; hook != expected {
+		t.Errorf("Expected service hook %+v, got %+v", expected, hook)
+	}
+	expected = models.Hook{Cmd: "drain", HostName: "host1", Timeout: 10, OnFailure: models.HookWarn}
+	if hook := *cfg.Services[0].Processes[0].Hooks.PreStop; hook != expected {
+		t.Errorf("Expected process hook %+v, got %+v", expected, hook)
+	}
+
+	cfg.Services[0].Hooks.PostStop.OnFailure = "ignore"
+	if err := utils.ValidateConfigAndBuildDependencyTree(cfg); err == nil {
+		t.Error("Expected an error for an unknown on_failure")
+	}
+}
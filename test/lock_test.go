@@ -0,0 +1,240 @@
+package test
+
+import (
+	"big-brother/internal/app"
+	"big-brother/internal/executor"
+	"big-brother/internal/lock"
+	"big-brother/internal/logger"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func lockBackends(t *testing.T) map[string]lock.Backend {
+	dir := t.TempDir()
+	return map[string]lock.Backend{
+		"file": lock.FileBackend{Path: filepath.Join(dir, "locks", "file.lock")},
+		// Runs the remote lock commands through the local runner
+		"remote": lock.RemoteBackend{
+			Executor: executor.NewExecutor(logger.NewLogger(false)),
+			HostName: "localhost",
+			Path:     filepath.Join(dir, "remote", "remote.lock"),
+			Timeout:  5,
+		},
+	}
+}
+
+func TestLock_SecondHolderIsRefused(t *testing.T) {
+	for name, backend := range lockBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			first := lock.NewHolder("alice", []string{"big-brother", "stop"})
+			held, err := lock.Acquire(ctx, backend, first, 0, nil)
+			if err != nil {
+				t.Fatalf("Acquire failed: %v", err)
+			}
+
+			second := lock.NewHolder("bob", []string{"big-brother", "start"})
+			_, err = lock.Acquire(ctx, backend, second, 0, nil)
+			var locked *lock.LockedError
+			if !errors.As(err, &locked) {
+				t.Fatalf("Expected a locked error, got: %v", err)
+			}
+			if locked.Holder.User != "alice" || locked.Holder.Command != "big-brother stop" || locked.Holder.PID != os.Getpid() {
+				t.Errorf("Unexpected holder: %+v", locked.Holder)
+			}
+
+			// Only the holder releases the lock
+			if err := backend.Unlock(ctx, second); err != nil {
+				t.Fatalf("Unlock failed: %v", err)
+			}
+			if _, err := lock.Acquire(ctx, backend, second, 0, nil); !lock.IsLocked(err) {
+				t.Errorf("Expected the lock to still be held, got: %v", err)
+			}
+
+			// A waiting holder gets the lock once it is released
+			go func() {
+				time.Sleep(200 * time.Millisecond)
+				held.Unlock(ctx)
+			}()
+			waited := false
+			next, err := lock.Acquire(ctx, backend, second, 5*time.Second, func(lock.Holder) { waited = true })
+			if err != nil || !waited {
+				t.Fatalf("Expected to wait for the lock, got waited=%v, err=%v", waited, err)
+			}
+
+			holder, err := backend.ForceUnlock(ctx)
+			if err != nil || holder == nil || holder.User != "bob" {
+				t.Fatalf("Expected to remove bob's lock, got %+v, %v", holder, err)
+			}
+			next.Unlock(ctx)
+			if _, err := lock.Acquire(ctx, backend, first, 0, nil); err != nil {
+				t.Errorf("Expected the lock to be free after a forced unlock, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestLock_ConcurrentHoldersNeverSeeAPartialLock(t *testing.T) {
+	for name, backend := range lockBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			// Remote lock commands go through a shell, so they get fewer rounds
+			rounds := 200
+			if name == "remote" {
+				rounds = 10
+			}
+			ctx := context.Background()
+			for round := 0; round < rounds; round++ {
+				var wg sync.WaitGroup
+				var mu sync.Mutex
+				var held []*lock.Lock
+				for range 16 {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						l, err := lock.Acquire(ctx, backend, lock.NewHolder("bob", nil), 0, nil)
+						if err == nil {
+							mu.Lock()
+							held = append(held, l)
+							mu.Unlock()
+						} else if !lock.IsLocked(err) {
+							t.Errorf("Acquire failed: %v", err)
+						}
+					}()
+				}
+				wg.Wait()
+				if len(held) != 1 {
+					t.Fatalf("Expected exactly one holder, got %d", len(held))
+				}
+				held[0].Unlock(ctx)
+			}
+		})
+	}
+}
+
+func TestLock_StaleLocalLockIsTakenOver(t *testing.T) {
+	backend := lock.FileBackend{Path: filepath.Join(t.TempDir(), "stale.lock")}
+	gone := lock.NewHolder("alice", nil)
+	gone.PID = 1 << 30
+	data, _ := json.Marshal(gone)
+	if err := os.WriteFile(backend.Path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := lock.Acquire(context.Background(), backend, lock.NewHolder("bob", nil), 0, nil); err != nil {
+		t.Errorf("Expected to take over the lock of a process that is gone, got: %v", err)
+	}
+}
+
+func TestLock_StaleLockIsTakenOverOnce(t *testing.T) {
+	backend := lock.FileBackend{Path: filepath.Join(t.TempDir(), "stale.lock")}
+	for round := 0; round < 200; round++ {
+		gone := lock.NewHolder("alice", nil)
+		gone.PID = 1 << 30
+		data, _ := json.Marshal(gone)
+		if err := os.WriteFile(backend.Path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		// Of several waiters finding the same stale lock, only one gets it
+		var wg sync.WaitGroup
+		var acquired atomic.Int32
+		for range 16 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := lock.Acquire(context.Background(), backend, lock.NewHolder("bob", nil), 0, nil); err == nil {
+					acquired.Add(1)
+				} else if !lock.IsLocked(err) {
+					t.Errorf("Acquire failed: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+		if acquired.Load() != 1 {
+			t.Fatalf("Expected exactly one waiter to take over the stale lock, %d did", acquired.Load())
+		}
+	}
+}
+
+func TestLock_RemoteLockIsSharedByCopiesOfTheConfig(t *testing.T) {
+	// Two operators with their own checkout of the same config
+	name := fmt.Sprintf("lock-test-%d-%d", os.Getpid(), time.Now().UnixNano())
+	config := "lock:\n  host_name: localhost\nservices:\n  - name: web\n"
+	var apps []*app.App
+	for _, operator := range []string{"alice", "bob"} {
+		apps = append(apps, newTestApp(t, writeConfig(t, filepath.Join(operator, name+".yaml"), config), false, false, ""))
+	}
+	t.Cleanup(func() { os.Remove(filepath.Join("/tmp/big-brother", name+".lock")) })
+
+	ctx := context.Background()
+	held, err := apps[0].Lock(ctx, 0)
+	if err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+	defer held.Unlock(ctx)
+	if _, err := apps[1].Lock(ctx, 0); !lock.IsLocked(err) {
+		t.Errorf("Expected the second copy of the config to find the lock held, got: %v", err)
+	}
+}
+
+func TestLock_LocalLockIsSharedByCopiesOfTheConfig(t *testing.T) {
+	// Two operators with their own checkout of the same config on one machine
+	name := fmt.Sprintf("lock-test-%d-%d", os.Getpid(), time.Now().UnixNano())
+	var apps []*app.App
+	for _, operator := range []string{"alice", "bob"} {
+		apps = append(apps, newTestApp(t, writeConfig(t, filepath.Join(operator, name+".yaml"), "services:\n  - name: web\n"), false, false, ""))
+	}
+	lockDir := filepath.Join(os.TempDir(), "big-brother")
+	t.Cleanup(func() { os.Remove(filepath.Join(lockDir, name+".lock")) })
+
+	ctx := context.Background()
+	held, err := apps[0].Lock(ctx, 0)
+	if err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+	defer held.Unlock(ctx)
+	if _, err := apps[1].Lock(ctx, 0); !lock.IsLocked(err) {
+		t.Errorf("Expected the second copy of the config to find the lock held, got: %v", err)
+	}
+
+	// Operators with other accounts take and remove locks there too
+	if info, err := os.Stat(lockDir); err != nil || (runtime.GOOS != "windows" && info.Mode().Perm() != 0o777) {
+		t.Errorf("Expected %s to be writable by everyone, got %v, %v", lockDir, info, err)
+	}
+}
+
+func TestLock_RemoteLockOutputIsNotStreamed(t *testing.T) {
+	var stream bytes.Buffer
+	newExecutor := executor.NewExecutor(logger.NewLogger(false))
+	newExecutor.SetStream(executor.NewStream(&stream, &stream))
+	dir := t.TempDir()
+	backend := lock.RemoteBackend{Executor: newExecutor, HostName: "localhost", Path: filepath.Join(dir, "remote.lock"), Timeout: 5}
+
+	outputDir := filepath.Join(dir, "output")
+	ctx := executor.WithOutputDir(context.Background(), outputDir)
+	held, err := lock.Acquire(ctx, backend, lock.NewHolder("alice", nil), 0, nil)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	if _, err := lock.Acquire(ctx, backend, lock.NewHolder("bob", nil), 0, nil); !lock.IsLocked(err) {
+		t.Fatalf("Expected the lock to be held, got: %v", err)
+	}
+	held.Unlock(ctx)
+
+	if stream.Len() > 0 {
+		t.Errorf("Expected no streamed output from lock commands, got %q", stream.String())
+	}
+	if _, err := os.Stat(outputDir); !os.IsNotExist(err) {
+		t.Errorf("Expected no output files from lock commands, got: %v", err)
+	}
+}
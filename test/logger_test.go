@@ -0,0 +1,84 @@
+package test
+
+import (
+	"big-brother/internal/executor"
+	"big-brother/internal/logger"
+	"big-brother/internal/models"
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLogger_LevelsAndFields(t *testing.T) {
+	var out bytes.Buffer
+	log, err := logger.New(logger.Options{Level: logger.LevelInfo, Output: &out})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	log.Debugf("hidden %d", 1)
+	log.With("service", "web").With("host", "db 1").With("process", "").Infof("shown %d", 2)
+	log.Warn("careful")
+	log.Fatal("bad")
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected 3 lines, got %q", out.String())
+	}
+	if !strings.HasSuffix(lines[0], ` [INFO] shown 2 service=web host="db 1"`) {
+		t.Errorf("Unexpected info line: %q", lines[0])
+	}
+	if !strings.HasSuffix(lines[1], " [WARN] careful") || !strings.HasSuffix(lines[2], " [FATAL] bad") {
+		t.Errorf("Unexpected lines: %q", lines[1:])
+	}
+	if log.Enabled(logger.LevelDebug) || !log.Enabled(logger.LevelError) {
+		t.Error("Expected debug to be disabled and error enabled at info level")
+	}
+
+	if _, err := logger.ParseLevel("loud"); err == nil {
+		t.Error("Expected an error for an unknown level")
+	}
+	if _, err := logger.New(logger.Options{Format: "xml"}); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
+
+func TestLogger_JSONFileWithExecutorFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "big-brother.log")
+	log, err := logger.New(logger.Options{Level: logger.LevelDebug, Format: logger.FormatJSON, File: path, MaxSize: 1, MaxFiles: 1})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	newExecutor := executor.NewExecutor(log)
+	ctx := executor.WithLabels(context.Background(), executor.Labels{RunID: "run1", Service: "service1"})
+	process := &models.Process{Name: "process1", HostName: "localhost", StartCmd: "echo started"}
+	if _, err := newExecutor.RunProcessAction(ctx, process, executor.ActionStart); err != nil {
+		t.Fatalf("RunProcessAction failed: %v", err)
+	}
+	if err := log.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var line map[string]any
+	if err := json.Unmarshal(bytes.SplitN(data, []byte("\n"), 2)[0], &line); err != nil {
+		t.Fatalf("Invalid JSON log line %q: %v", data, err)
+	}
+	want := map[string]any{"level": "debug", "run": "run1", "service": "service1", "process": "process1", "host": "localhost"}
+	for key, value := range want {
+		if line[key] != value {
+			t.Errorf("Expected %s=%v, got %v in %v", key, value, line[key], line)
+		}
+	}
+	if msg, _ := line["msg"].(string); !strings.Contains(msg, "echo started") {
+		t.Errorf("Expected the command in the message, got %q", msg)
+	}
+}
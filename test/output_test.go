@@ -0,0 +1,77 @@
+package test
+
+import (
+	"big-brother/internal/executor"
+	"big-brother/internal/logger"
+	"big-brother/internal/models"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestExecutor_StreamsPrefixedOutput(t *testing.T) {
+	newExecutor := executor.NewExecutor(logger.NewLogger(false))
+	var stdout, stderr bytes.Buffer
+	newExecutor.SetStream(executor.NewStream(&stdout, &stderr))
+	dir := filepath.Join(t.TempDir(), "output")
+	ctx := executor.WithOutputDir(executor.WithLabels(context.Background(), executor.Labels{Service: "web"}), dir)
+
+	// Commands of parallel processes interleave line by line
+	var wg sync.WaitGroup
+	for i := 1; i <= 4; i++ {
+		process := &models.Process{
+			Name:      fmt.Sprintf("api%d", i),
+			HostName:  "localhost",
+			Shell:     true,
+			StartCmd:  fmt.Sprintf("for n in 1 2 3 4 5; do echo line$n; done; echo warning >&2; printf api%d", i),
+			StatusCmd: "echo checked",
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := newExecutor.RunProcessAction(ctx, process, executor.ActionStart); err != nil {
+				t.Errorf("RunProcessAction failed: %v", err)
+			}
+			if _, err := newExecutor.RunProcessAction(ctx, process, executor.ActionStatus); err != nil {
+				t.Errorf("RunProcessAction failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	lines := strings.Split(strings.TrimSuffix(stdout.String(), "\n"), "\n")
+	if len(lines) != 4*6 {
+		t.Fatalf("Expected 24 lines, got %q", stdout.String())
+	}
+	for _, line := range lines {
+		prefix, text, _ := strings.Cut(line, " ")
+		process := strings.TrimSuffix(strings.TrimPrefix(prefix, "[web/"), "@localhost]")
+		if !strings.HasPrefix(line, "[web/api") || (!strings.HasPrefix(text, "line") && text != process) {
+			t.Errorf("Unexpected line %q", line)
+		}
+	}
+	if strings.Count(stderr.String(), "warning\n") != 4 || !strings.Contains(stderr.String(), "[web/api3@localhost] warning\n") {
+		t.Errorf("Unexpected stderr: %q", stderr.String())
+	}
+
+	// Every start is saved, status checks are not
+	files, _ := filepath.Glob(filepath.Join(dir, "*.log"))
+	if len(files) != 4 {
+		t.Fatalf("Expected 4 output files, got %v", files)
+	}
+	if !strings.HasPrefix(filepath.Base(files[0]), "001-web-api") || !strings.HasSuffix(files[0], "-start.log") {
+		t.Errorf("Unexpected output file name %s", files[0])
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "line1\nline2\nline3\nline4\nline5\n") || !strings.Contains(string(data), "warning\n") {
+		t.Errorf("Unexpected output file: %q", data)
+	}
+}
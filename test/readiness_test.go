@@ -0,0 +1,99 @@
+package test
+
+import (
+	"big-brother/internal/executor"
+	"big-brother/internal/logger"
+	"big-brother/internal/models"
+	"big-brother/internal/utils"
+	"context"
+	"testing"
+	"time"
+)
+
+func newFakeExecutor(t *testing.T) (*executor.Executor, *executor.FakeRunner) {
+	t.Helper()
+
+	newExecutor := executor.NewExecutor(logger.NewLogger(false))
+	t.Cleanup(newExecutor.Close)
+	fake := executor.NewFakeRunner()
+	newExecutor.RegisterRunner(executor.RunnerLocal, fake)
+	return newExecutor, fake
+}
+
+func TestExecutor_WaitForStateReturnsOnceReady(t *testing.T) {
+	newExecutor, fake := newFakeExecutor(t)
+	fake.On("localhost", "check",
+		executor.FakeResponse{Output: ""},
+		executor.FakeResponse{Output: "running"})
+
+	process := &models.Process{Name: "process1", HostName: "localhost", StatusCmd: "check", ReadyTimeout: 10, PollInterval: 1}
+
+	started := time.Now()
+	if err := newExecutor.WaitForState(context.Background(), process, models.StateRunning); err != nil {
+		t.Fatalf("WaitForState failed: %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 3*time.Second {
+		t.Errorf("WaitForState kept polling after the process was ready: %s", elapsed)
+	}
+	if calls := len(fake.Calls()); calls != 2 {
+		t.Errorf("Expected 2 status checks, got %d", calls)
+	}
+}
+
+func TestExecutor_WaitForStateGivesUpAfterReadyTimeout(t *testing.T) {
+	newExecutor, _ := newFakeExecutor(t)
+
+	process := &models.Process{Name: "process1", HostName: "localhost", StatusCmd: "check", ReadyTimeout: 1, PollInterval: 1}
+
+	started := time.Now()
+	if err := newExecutor.WaitForState(context.Background(), process, models.StateRunning); err == nil {
+		t.Fatal("Expected WaitForState to fail for a process that never starts")
+	}
+	if elapsed := time.Since(started); elapsed < time.Second || elapsed > 3*time.Second {
+		t.Errorf("Expected to give up after about 1s, took %s", elapsed)
+	}
+}
+
+func TestExecutor_WaitForStateAcceptsDegradedAsRunning(t *testing.T) {
+	newExecutor, fake := newFakeExecutor(t)
+	fake.On("localhost", "check", executor.FakeResponse{Output: "degraded", ExitCode: 2})
+
+	process := &models.Process{Name: "process1", HostName: "localhost", StatusCmd: "check", ReadyTimeout: 10, PollInterval: 1,
+		Status: &models.StatusPolicy{DegradedExitCodes: []int{2}}}
+	if err := newExecutor.WaitForState(context.Background(), process, models.StateRunning); err != nil {
+		t.Fatalf("Expected a degraded process to count as started, got: %v", err)
+	}
+	if calls := len(fake.Calls()); calls != 1 {
+		t.Errorf("Expected 1 status check, got %d", calls)
+	}
+
+	// It is not stopped, though
+	process.ReadyTimeout = 0
+	if err := newExecutor.WaitForState(context.Background(), process, models.StateStopped); err == nil {
+		t.Error("Expected a degraded process not to count as stopped")
+	}
+}
+
+func TestValidateConfig_ReadinessDefaults(t *testing.T) {
+	cfg := &models.Config{
+		WaitTime: 5,
+		Services: []models.Service{
+			{Name: "service1", PollInterval: 2, Processes: []models.Process{
+				{Name: "process1"},
+				{Name: "process2", ReadyTimeout: 60, InitialDelay: 3},
+			}},
+		},
+	}
+
+	if err := utils.ValidateConfigAndBuildDependencyTree(cfg); err != nil {
+		t.Fatalf("ValidateConfigAndBuildDependencyTree failed: %v", err)
+	}
+
+	process1, process2 := cfg.Services[0].Processes[0], cfg.Services[0].Processes[1]
+	if process1.ReadyTimeout != 5 || process1.PollInterval != 2 || process1.InitialDelay != 0 {
+		t.Errorf("Unexpected readiness settings for process1: %+v", process1)
+	}
+	if process2.ReadyTimeout != 60 || process2.PollInterval != 2 || process2.InitialDelay != 3 {
+		t.Errorf("Unexpected readiness settings for process2: %+v", process2)
+	}
+}
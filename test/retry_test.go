@@ -0,0 +1,157 @@
+package test
+
+import (
+	"big-brother/internal/executor"
+	"big-brother/internal/models"
+	"big-brother/internal/utils"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExecutor_RetriesStartUntilSuccess(t *testing.T) {
+	newExecutor, fake := newFakeExecutor(t)
+	fake.On("localhost", "start",
+		executor.FakeResponse{ExitCode: 1},
+		executor.FakeResponse{ExitCode: 1},
+		executor.FakeResponse{Output: "ok"})
+
+	process := &models.Process{Name: "process1", HostName: "localhost", StartCmd: "start", Retry: &models.RetryPolicy{Attempts: 3}}
+	if _, err := newExecutor.RunProcessAction(context.Background(), process, executor.ActionStart); err != nil {
+		t.Fatalf("Expected the third attempt to succeed, got: %v", err)
+	}
+	if calls := len(fake.Calls()); calls != 3 {
+		t.Errorf("Expected 3 attempts, got %d", calls)
+	}
+}
+
+func TestExecutor_RetriesAreRecorded(t *testing.T) {
+	newExecutor, fake := newFakeExecutor(t)
+	fake.On("localhost", "start",
+		executor.FakeResponse{ExitCode: 1},
+		executor.FakeResponse{Output: "ok"})
+
+	var attempts []int
+	ctx := executor.WithRecorder(context.Background(), func(record models.CommandRecord) {
+		attempts = append(attempts, record.Attempt)
+	})
+	process := &models.Process{Name: "process1", HostName: "localhost", StartCmd: "start", Retry: &models.RetryPolicy{Attempts: 3}}
+	result, err := newExecutor.RunProcessActionResult(ctx, process, executor.ActionStart)
+	if err != nil || result.Attempts != 2 {
+		t.Errorf("Expected success after 2 attempts, got %d attempts, %v", result.Attempts, err)
+	}
+	if !reflect.DeepEqual(attempts, []int{1, 2}) {
+		t.Errorf("Expected the attempts to be recorded, got %v", attempts)
+	}
+}
+
+func TestApp_RetriesAreNoted(t *testing.T) {
+	newApp, fake := newFakeAppWith(t, writeConfig(t, "config.yaml", `services:
+  - name: service1
+    processes:
+      - name: process1
+        host_name: localhost
+        start_cmd: start
+        status_cmd: status
+        retry:
+          attempts: 3
+`), false, true, "")
+	fake.On("localhost", "start",
+		executor.FakeResponse{ExitCode: 1},
+		executor.FakeResponse{Output: "ok"})
+	fake.On("localhost", "status", executor.FakeResponse{Output: "running"})
+
+	results, err := newApp.StartService(context.Background(), "service1")
+	if err != nil {
+		t.Fatalf("StartService failed: %v", err)
+	}
+	if expected := []string{"process1@localhost: start succeeded after 2 attempts"}; len(results) != 1 || !reflect.DeepEqual(results[0].Notes, expected) {
+		t.Errorf("Expected notes %v, got %+v", expected, results)
+	}
+}
+
+func TestExecutor_RetryGivesUpAfterMaxAttempts(t *testing.T) {
+	newExecutor, fake := newFakeExecutor(t)
+	fake.On("localhost", "start", executor.FakeResponse{ExitCode: 75})
+
+	process := &models.Process{Name: "process1", HostName: "localhost", StartCmd: "start", Retry: &models.RetryPolicy{Attempts: 2, ExitCodes: []int{75}}}
+	_, err := newExecutor.RunProcessAction(context.Background(), process, executor.ActionStart)
+	if err == nil || !strings.Contains(err.Error(), "after 2 attempts") {
+		t.Errorf("Expected a failure after 2 attempts, got: %v", err)
+	}
+	if calls := len(fake.Calls()); calls != 2 {
+		t.Errorf("Expected 2 attempts, got %d", calls)
+	}
+}
+
+func TestExecutor_RetryOnlyListedExitCodes(t *testing.T) {
+	newExecutor, fake := newFakeExecutor(t)
+	fake.On("localhost", "stop", executor.FakeResponse{ExitCode: 1})
+
+	process := &models.Process{Name: "process1", HostName: "localhost", StopCmd: "stop", Retry: &models.RetryPolicy{Attempts: 3, ExitCodes: []int{75}}}
+	if _, err := newExecutor.RunProcessAction(context.Background(), process, executor.ActionStop); err == nil {
+		t.Error("Expected the stop command to fail")
+	}
+	if calls := len(fake.Calls()); calls != 1 {
+		t.Errorf("Expected no retry for an unlisted exit code, got %d attempts", calls)
+	}
+}
+
+func TestExecutor_StatusRetriedOnTimeoutOnly(t *testing.T) {
+	newExecutor, fake := newFakeExecutor(t)
+	fake.On("localhost", "status",
+		executor.FakeResponse{Delay: 5 * time.Second},
+		executor.FakeResponse{ExitCode: 3})
+
+	process := &models.Process{Name: "process1", HostName: "localhost", StatusCmd: "status", Timeout: 1, Retry: &models.RetryPolicy{Attempts: 3, OnTimeout: true}}
+	state, err := newExecutor.CheckProcess(context.Background(), process)
+	if err != nil || state != models.StateStopped {
+		t.Errorf("Expected stopped after retrying the timeout, got %s: %v", state, err)
+	}
+	if calls := len(fake.Calls()); calls != 2 {
+		t.Errorf("Expected the exit code to end the retries after 2 attempts, got %d", calls)
+	}
+}
+
+func TestExecutor_NoRetryOnTimeoutByDefault(t *testing.T) {
+	newExecutor, fake := newFakeExecutor(t)
+	fake.On("localhost", "start", executor.FakeResponse{Delay: 5 * time.Second})
+
+	process := &models.Process{Name: "process1", HostName: "localhost", StartCmd: "start", Timeout: 1, Retry: &models.RetryPolicy{Attempts: 3}}
+	_, err := newExecutor.RunProcessAction(context.Background(), process, executor.ActionStart)
+	if !errors.Is(err, executor.ErrTimedOut) {
+		t.Errorf("Expected a timeout, got: %v", err)
+	}
+	if calls := len(fake.Calls()); calls != 1 {
+		t.Errorf("Expected no retry, got %d attempts", calls)
+	}
+}
+
+func TestValidateConfig_RetryPolicy(t *testing.T) {
+	newConfig := func(retry *models.RetryPolicy) *models.Config {
+		return &models.Config{Services: []models.Service{
+			{Name: "service1", Processes: []models.Process{{Name: "process1", Retry: retry}}},
+		}}
+	}
+
+	cfg := newConfig(&models.RetryPolicy{Attempts: 3, Backoff: models.BackoffExponential})
+	if err := utils.ValidateConfigAndBuildDependencyTree(cfg); err != nil {
+		t.Fatalf("Valid retry policy rejected: %v", err)
+	}
+	if delay := cfg.Services[0].Processes[0].Retry.Delay; delay != 1 {
+		t.Errorf("Expected default retry delay 1, got %d", delay)
+	}
+
+	for _, retry := range []*models.RetryPolicy{
+		{Backoff: "linear"},
+		{Attempts: -1},
+		{Jitter: 1.5},
+	} {
+		if err := utils.ValidateConfigAndBuildDependencyTree(newConfig(retry)); err == nil {
+			t.Errorf("Expected invalid retry policy %+v to be rejected", retry)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package test
+
+import (
+	"big-brother/internal/models"
+	"big-brother/internal/scheduler"
+	"big-brother/internal/utils"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// buildServices validates a config made of the given name -> dependencies map
+// and returns its services in start order.
+func buildServices(t *testing.T, deps [][2]string, names ...string) []*models.Service {
+	t.Helper()
+
+	cfg := &models.Config{}
+	for _, name := range names {
+		service := models.Service{Name: name}
+		for _, dep := range deps {
+			if dep[0] == name {
+				service.DependsOn = append(service.DependsOn, dep[1])
+			}
+		}
+		cfg.Services = append(cfg.Services, service)
+	}
+	if err := utils.ValidateConfigAndBuildDependencyTree(cfg); err != nil {
+		t.Fatalf("ValidateConfigAndBuildDependencyTree failed: %v", err)
+	}
+	return cfg.StartOrder
+}
+
+func resultStatuses(results []scheduler.Result) map[string]scheduler.Status {
+	statuses := make(map[string]scheduler.Status)
+	for _, result := range results {
+		statuses[result.Service.Name] = result.Status
+	}
+	return statuses
+}
+
+func TestScheduler_RunsServiceAsSoonAsPrerequisitesSucceed(t *testing.T) {
+	// slow and fast are roots; after needs fast only
+	services := buildServices(t, [][2]string{{"after", "fast"}}, "slow", "fast", "after")
+
+	var mu sync.Mutex
+	var order []string
+	record := func(event string) {
+		mu.Lock()
+		defer mu.Unlock()
+		order = append(order, event)
+	}
+
+	results := scheduler.Run(context.Background(), services, utils.Dependencies, 2, false, func(ctx context.Context, s *models.Service) error {
+		if s.Name == "slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		record(s.Name)
+		return nil
+	})
+
+	if len(scheduler.Failed(results)) != 0 {
+		t.Fatalf("Unexpected failures: %+v", results)
+	}
+	if order[len(order)-1] != "slow" {
+		t.Errorf("Expected after to run without waiting for slow, got order %v", order)
+	}
+}
+
+func TestScheduler_ConcurrencyCap(t *testing.T) {
+	services := buildServices(t, [][2]string{{"c", "a"}, {"d", "b"}, {"e", "c"}, {"e", "d"}}, "a", "b", "c", "d", "e", "f", "g")
+
+	for _, concurrency := range []int{1, 2, 3} {
+		var mu sync.Mutex
+		active, maxActive := 0, 0
+		results := scheduler.Run(context.Background(), services, utils.Dependencies, concurrency, false, func(ctx context.Context, s *models.Service) error {
+			mu.Lock()
+			active++
+			maxActive = max(maxActive, active)
+			mu.Unlock()
+
+			time.Sleep(20 * time.Millisecond)
+
+			mu.Lock()
+			active--
+			mu.Unlock()
+			return nil
+		})
+
+		if len(scheduler.Failed(results)) != 0 {
+			t.Errorf("Unexpected failures with concurrency %d: %+v", concurrency, results)
+		}
+		if maxActive > concurrency {
+			t.Errorf("Concurrency %d exceeded: %d actions ran at once", concurrency, maxActive)
+		}
+	}
+}
+
+func TestScheduler_FailureSkipsOnlyDependents(t *testing.T) {
+	services := buildServices(t, [][2]string{{"app", "db"}, {"app", "cache"}, {"web", "app"}, {"worker", "cache"}}, "db", "cache", "app", "web", "worker")
+
+	results := scheduler.Run(context.Background(), services, utils.Dependencies, 1, false, func(ctx context.Context, s *models.Service) error {
+		if s.Name == "db" {
+			return errors.New("boom")
+		}
+		return nil
+	})
+
+	expected := map[string]scheduler.Status{
+		"db":     scheduler.StatusFailed,
+		"cache":  scheduler.StatusSucceeded,
+		"app":    scheduler.StatusSkipped,
+		"web":    scheduler.StatusSkipped,
+		"worker": scheduler.StatusSucceeded,
+	}
+	statuses := resultStatuses(results)
+	for name, status := range expected {
+		if statuses[name] != status {
+			t.Errorf("Service %s: expected %s, got %s", name, status, statuses[name])
+		}
+	}
+}
+
+func TestScheduler_FailFastStartsNothingNew(t *testing.T) {
+	services := buildServices(t, [][2]string{{"app", "db"}}, "db", "cache", "app", "worker")
+
+	results := scheduler.Run(context.Background(), services, utils.Dependencies, 1, true, func(ctx context.Context, s *models.Service) error {
+		if s.Name == "cache" {
+			return errors.New("boom")
+		}
+		return nil
+	})
+
+	expected := map[string]scheduler.Status{
+		"db":     scheduler.StatusSucceeded,
+		"cache":  scheduler.StatusFailed,
+		"app":    scheduler.StatusSkipped,
+		"worker": scheduler.StatusSkipped,
+	}
+	statuses := resultStatuses(results)
+	for name, status := range expected {
+		if statuses[name] != status {
+			t.Errorf("Service %s: expected %s, got %s", name, status, statuses[name])
+		}
+	}
+}
+
+func TestScheduler_CancelledContextStartsNothingNew(t *testing.T) {
+	services := buildServices(t, [][2]string{{"b", "a"}}, "a", "b")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	results := scheduler.Run(ctx, services, utils.Dependencies, 1, false, func(ctx context.Context, s *models.Service) error {
+		cancel()
+		return nil
+	})
+
+	statuses := resultStatuses(results)
+	if statuses["a"] != scheduler.StatusSucceeded || statuses["b"] != scheduler.StatusSkipped {
+		t.Errorf("Unexpected statuses after cancel: %v", statuses)
+	}
+}
+
+func TestScheduler_SatisfiedCountsAsSuccess(t *testing.T) {
+	services := buildServices(t, [][2]string{{"b", "a"}}, "a", "b")
+
+	results := scheduler.Run(context.Background(), services, utils.Dependencies, 1, true, func(ctx context.Context, s *models.Service) error {
+		if s.Name == "a" {
+			return scheduler.Satisfied{Status: scheduler.StatusAlreadyRunning}
+		}
+		return nil
+	})
+
+	statuses := resultStatuses(results)
+	if statuses["a"] != scheduler.StatusAlreadyRunning || statuses["b"] != scheduler.StatusSucceeded {
+		t.Errorf("Unexpected statuses: %v", statuses)
+	}
+	if failed := scheduler.Failed(results); len(failed) != 0 {
+		t.Errorf("Expected no failures, got: %+v", failed)
+	}
+}
+
+func TestScheduler_NotesAreRecordedPerService(t *testing.T) {
+	services := buildServices(t, nil, "a", "b")
+
+	results := scheduler.Run(context.Background(), services, utils.Dependencies, 2, false, func(ctx context.Context, s *models.Service) error {
+		if s.Name == "a" {
+			scheduler.Note(ctx, "note for %s", s.Name)
+		}
+		return nil
+	})
+
+	if len(results[0].Notes) != 1 || results[0].Notes[0] != "note for a" || len(results[1].Notes) != 0 {
+		t.Errorf("Unexpected notes: %v, %v", results[0].Notes, results[1].Notes)
+	}
+}
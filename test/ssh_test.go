@@ -0,0 +1,399 @@
+package test
+
+import (
+	"big-brother/internal/executor"
+	"big-brother/internal/logger"
+	"big-brother/internal/models"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// testSSHServer is an in-process stand-in for sshd that runs exec requests
+// through the local shell.
+type testSSHServer struct {
+	listener net.Listener
+	hostKey  ssh.PublicKey
+	config   *ssh.ServerConfig
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func newTestSSHServer(t *testing.T, authorizedKey ssh.PublicKey) *testSSHServer {
+	t.Helper()
+
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating host key: %v", err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatalf("error creating host signer: %v", err)
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) == string(authorizedKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unauthorized key")
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+
+	server := &testSSHServer{listener: listener, hostKey: hostSigner.PublicKey(), config: config}
+	go server.serve()
+	t.Cleanup(func() { listener.Close() })
+	return server
+}
+
+func (s *testSSHServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *testSSHServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns = append(s.conns, conn)
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+// dropConnections closes every client connection, as a restarting sshd
+// would.
+func (s *testSSHServer) dropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *testSSHServer) handle(conn net.Conn) {
+	_, channels, requests, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			defer channel.Close()
+			for req := range channelRequests {
+				if req.Type != "exec" {
+					req.Reply(false, nil)
+					continue
+				}
+				req.Reply(true, nil)
+
+				length := binary.BigEndian.Uint32(req.Payload[:4])
+				command := string(req.Payload[4 : 4+length])
+				cmd := exec.Command("/bin/sh", "-c", command)
+				cmd.Stdout = channel
+				cmd.Stderr = channel.Stderr()
+
+				exitCode := 0
+				if err := cmd.Run(); err != nil {
+					exitCode = 1
+					var exitErr *exec.ExitError
+					if errors.As(err, &exitErr) {
+						exitCode = exitErr.ExitCode()
+					}
+				}
+				status := make([]byte, 4)
+				binary.BigEndian.PutUint32(status, uint32(exitCode))
+				channel.SendRequest("exit-status", false, status)
+				return
+			}
+		}()
+	}
+}
+
+// writeClientKey writes a fresh private key to dir and returns its path and
+// public half.
+func writeClientKey(t *testing.T, dir string) (string, ssh.PublicKey) {
+	t.Helper()
+
+	_, clientPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating client key: %v", err)
+	}
+	block, err := ssh.MarshalPrivateKey(clientPriv, "")
+	if err != nil {
+		t.Fatalf("error marshaling client key: %v", err)
+	}
+	keyFile := filepath.Join(dir, "id_ed25519")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatalf("error writing client key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(clientPriv)
+	if err != nil {
+		t.Fatalf("error creating client signer: %v", err)
+	}
+	return keyFile, signer.PublicKey()
+}
+
+func writeKnownHosts(t *testing.T, dir string, port int, key ssh.PublicKey) string {
+	t.Helper()
+
+	address := knownhosts.Normalize(net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	knownHostsFile := filepath.Join(dir, "known_hosts")
+	if err := os.WriteFile(knownHostsFile, []byte(knownhosts.Line([]string{address}, key)+"\n"), 0600); err != nil {
+		t.Fatalf("error writing known_hosts: %v", err)
+	}
+	return knownHostsFile
+}
+
+func TestExecutor_ExecuteCommandOverSSH(t *testing.T) {
+	dir := t.TempDir()
+	keyFile, clientKey := writeClientKey(t, dir)
+	server := newTestSSHServer(t, clientKey)
+	knownHostsFile := writeKnownHosts(t, dir, server.port(), server.hostKey)
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	newExecutor := executor.NewExecutor(logger.NewLogger(false))
+	newExecutor.ConfigureHosts(
+		models.SSHConfig{User: "tester", KnownHosts: knownHostsFile},
+		[]models.Host{{Name: "remote1", Address: "127.0.0.1", Port: server.port(), KeyFile: keyFile}},
+	)
+	defer newExecutor.Close()
+
+	output, err := newExecutor.ExecuteCommand(context.Background(), "echo hello from remote", "remote1")
+	if err != nil {
+		t.Fatalf("ExecuteCommand failed over ssh: %v", err)
+	}
+	if output != "hello from remote\n" {
+		t.Errorf("Unexpected output: %q", output)
+	}
+
+	_, err = newExecutor.ExecuteCommand(context.Background(), "exit 3", "remote1")
+	if err == nil {
+		t.Error("ExecuteCommand should have failed for non-zero remote exit")
+	}
+}
+
+func TestExecutor_SSHRejectsUnknownHostKey(t *testing.T) {
+	dir := t.TempDir()
+	keyFile, clientKey := writeClientKey(t, dir)
+	server := newTestSSHServer(t, clientKey)
+	_, otherKey := writeClientKey(t, t.TempDir())
+	knownHostsFile := writeKnownHosts(t, dir, server.port(), otherKey)
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	newExecutor := executor.NewExecutor(logger.NewLogger(false))
+	newExecutor.ConfigureHosts(
+		models.SSHConfig{User: "tester", KnownHosts: knownHostsFile, KeyFile: keyFile, Port: server.port()},
+		[]models.Host{{Name: "remote1", Address: "127.0.0.1"}},
+	)
+	defer newExecutor.Close()
+
+	if _, err := newExecutor.ExecuteCommand(context.Background(), "echo hello", "remote1"); err == nil {
+		t.Error("ExecuteCommand should have failed for a host key missing from known_hosts")
+	}
+}
+
+func TestExecutor_SSHRedialsAndSharesAgent(t *testing.T) {
+	// An ssh agent holding the only authorized key, counting its connections
+	_, clientPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: clientPriv}); err != nil {
+		t.Fatal(err)
+	}
+	signers, _ := keyring.Signers()
+	agentDir, err := os.MkdirTemp("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(agentDir) })
+	socket := filepath.Join(agentDir, "agent.sock")
+	agentListener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { agentListener.Close() })
+	var agentConns atomic.Int32
+	go func() {
+		for {
+			conn, err := agentListener.Accept()
+			if err != nil {
+				return
+			}
+			agentConns.Add(1)
+			go agent.ServeAgent(keyring, conn)
+		}
+	}()
+	t.Setenv("SSH_AUTH_SOCK", socket)
+
+	dir := t.TempDir()
+	server := newTestSSHServer(t, signers[0].PublicKey())
+	knownHostsFile := writeKnownHosts(t, dir, server.port(), server.hostKey)
+	newExecutor := executor.NewExecutor(logger.NewLogger(false))
+	newExecutor.ConfigureHosts(
+		models.SSHConfig{User: "tester", KnownHosts: knownHostsFile, Port: server.port()},
+		[]models.Host{{Name: "remote1", Address: "127.0.0.1"}, {Name: "remote2", Address: "127.0.0.1"}},
+	)
+	defer newExecutor.Close()
+
+	run := func(hostName string) {
+		t.Helper()
+		if _, err := newExecutor.ExecuteCommand(context.Background(), "echo hello", hostName); err != nil {
+			t.Fatalf("ExecuteCommand on %s failed: %v", hostName, err)
+		}
+	}
+	run("remote1")
+	run("remote2")
+
+	// After sshd went away, the next command connects again
+	server.dropConnections()
+	run("remote1")
+	run("remote2")
+
+	if n := agentConns.Load(); n != 1 {
+		t.Errorf("Expected one connection to the ssh agent, got %d", n)
+	}
+}
+
+func TestExecutor_SSHTimeoutStopsStreamingOutput(t *testing.T) {
+	dir := t.TempDir()
+	keyFile, clientKey := writeClientKey(t, dir)
+	server := newTestSSHServer(t, clientKey)
+	knownHostsFile := writeKnownHosts(t, dir, server.port(), server.hostKey)
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	var stream lockedBuffer
+	newExecutor := executor.NewExecutor(logger.NewLogger(false))
+	newExecutor.SetStream(executor.NewStream(&stream, &stream))
+	newExecutor.ConfigureHosts(
+		models.SSHConfig{User: "tester", KnownHosts: knownHostsFile},
+		[]models.Host{{Name: "remote1", Address: "127.0.0.1", Port: server.port(), KeyFile: keyFile}},
+	)
+	defer newExecutor.Close()
+
+	// A command printing until it times out
+	ctx := executor.WithLabels(context.Background(), executor.Labels{Service: "service1"})
+	ctx = executor.WithOutputDir(ctx, filepath.Join(dir, "output"))
+	process := &models.Process{Name: "process1", HostName: "remote1", Shell: true, Timeout: 1, StartCmd: "while :; do echo tick; done"}
+	if _, err := newExecutor.RunProcessAction(ctx, process, executor.ActionStart); err == nil {
+		t.Fatal("Expected the command to time out")
+	}
+
+	// Nothing is written once the command has returned
+	before := stream.Len()
+	time.Sleep(200 * time.Millisecond)
+	if after := stream.Len(); after != before {
+		t.Errorf("Expected no output after the command returned, got %d more bytes", after-before)
+	}
+}
+
+// lockedBuffer is a bytes.Buffer safe to read while being written.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Len()
+}
+
+func TestExecutor_SSHSlowHostDoesNotHoldUpOthers(t *testing.T) {
+	dir := t.TempDir()
+	keyFile, clientKey := writeClientKey(t, dir)
+	server := newTestSSHServer(t, clientKey)
+	knownHostsFile := writeKnownHosts(t, dir, server.port(), server.hostKey)
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	// A host that accepts connections and never answers
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := silent.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+
+	newExecutor := executor.NewExecutor(logger.NewLogger(false))
+	newExecutor.ConfigureHosts(
+		models.SSHConfig{User: "tester", KnownHosts: knownHostsFile, KeyFile: keyFile},
+		[]models.Host{
+			{Name: "remote1", Address: "127.0.0.1", Port: server.port()},
+			{Name: "silent", Address: "127.0.0.1", Port: silent.Addr().(*net.TCPAddr).Port},
+		},
+	)
+
+	slow := make(chan error, 1)
+	go func() {
+		_, err := newExecutor.ExecuteCommand(context.Background(), "echo hello", "silent")
+		slow <- err
+	}()
+	conn := <-accepted
+	defer conn.Close()
+	// Should the other host wait after all, let it fail instead of hanging
+	time.AfterFunc(5*time.Second, func() { conn.Close() })
+
+	started := time.Now()
+	if _, err := newExecutor.ExecuteCommand(context.Background(), "echo hello", "remote1"); err != nil {
+		t.Fatalf("ExecuteCommand failed: %v", err)
+	}
+	newExecutor.Close()
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Errorf("Expected the other host not to wait for the silent one, took %s", elapsed)
+	}
+
+	conn.Close()
+	if err := <-slow; err == nil {
+		t.Error("Expected the command on the silent host to fail")
+	}
+}
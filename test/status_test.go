@@ -0,0 +1,75 @@
+package test
+
+import (
+	"big-brother/internal/executor"
+	"big-brother/internal/logger"
+	"big-brother/internal/models"
+	"context"
+	"testing"
+)
+
+func TestEvaluateStatus(t *testing.T) {
+	systemctl := &models.StatusPolicy{StoppedExitCodes: []int{3}}
+	pgrep := &models.StatusPolicy{StoppedExitCodes: []int{1}}
+	output := &models.StatusPolicy{StdoutMatch: `active \(running\)`, StderrNotMatch: "error", DegradedMatch: "degraded"}
+	health := &models.StatusPolicy{JSONField: "status.overall", JSONEquals: []string{"UP"}, DegradedExitCodes: []int{2}}
+
+	tests := []struct {
+		name     string
+		policy   *models.StatusPolicy
+		result   executor.Result
+		expected models.ProcessState
+		err      bool
+	}{
+		{"legacy running", nil, executor.Result{Stdout: "1234"}, models.StateRunning, false},
+		{"legacy empty output", nil, executor.Result{}, models.StateStopped, false},
+		{"legacy non-zero exit", nil, executor.Result{Stdout: "oops", ExitCode: 1}, models.StateStopped, false},
+		{"systemctl active", systemctl, executor.Result{Stdout: "active"}, models.StateRunning, false},
+		{"systemctl inactive", systemctl, executor.Result{Stdout: "inactive", ExitCode: 3}, models.StateStopped, false},
+		{"systemctl unexpected code", systemctl, executor.Result{ExitCode: 4}, models.StateUnknown, true},
+		{"pgrep found with no output check", pgrep, executor.Result{}, models.StateRunning, false},
+		{"pgrep not found", pgrep, executor.Result{ExitCode: 1}, models.StateStopped, false},
+		{"stdout match", output, executor.Result{Stdout: "Active: active (running)"}, models.StateRunning, false},
+		{"stdout mismatch", output, executor.Result{Stdout: "Active: failed"}, models.StateStopped, false},
+		{"stderr not match", output, executor.Result{Stdout: "active (running)", Stderr: "error: disk"}, models.StateStopped, false},
+		{"degraded match", output, executor.Result{Stdout: "active (running) degraded"}, models.StateDegraded, false},
+		{"json up", health, executor.Result{Stdout: `{"status": {"overall": "UP"}}`}, models.StateRunning, false},
+		{"json down", health, executor.Result{Stdout: `{"status": {"overall": "DOWN"}}`}, models.StateStopped, false},
+		{"json degraded code", health, executor.Result{ExitCode: 2}, models.StateDegraded, false},
+		{"json unparsable", health, executor.Result{Stdout: "not json"}, models.StateUnknown, true},
+		{"json missing field", health, executor.Result{Stdout: `{"status": {}}`}, models.StateUnknown, true},
+	}
+
+	for _, test := range tests {
+		state, err := executor.EvaluateStatus(test.policy, test.result)
+		if state != test.expected || (err != nil) != test.err {
+			t.Errorf("%s: got state %s, err %v; expected %s, err %v", test.name, state, err, test.expected, test.err)
+		}
+	}
+}
+
+func TestExecutor_CheckServiceStates(t *testing.T) {
+	newExecutor := executor.NewExecutor(logger.NewLogger(false))
+	fake := executor.NewFakeRunner()
+	newExecutor.RegisterRunner(executor.RunnerLocal, fake)
+	fake.On("localhost", "systemctl is-active app", executor.FakeResponse{Output: "inactive\n", ExitCode: 3})
+	fake.On("localhost", "health", executor.FakeResponse{Output: `{"status": "DEGRADED"}`, ExitCode: 2})
+
+	service := &models.Service{
+		Name: "service1",
+		Processes: []models.Process{
+			{Name: "process1", HostName: "localhost", StatusCmd: "systemctl is-active app",
+				Status: &models.StatusPolicy{StoppedExitCodes: []int{3}}},
+			{Name: "process2", HostName: "localhost", StatusCmd: "health",
+				Status: &models.StatusPolicy{DegradedExitCodes: []int{2}}},
+		},
+	}
+
+	results := newExecutor.CheckService(context.Background(), service)
+	if results[0].State != models.StateStopped || results[0].IsRunning {
+		t.Errorf("Expected process1 to be stopped, got: %+v", results[0])
+	}
+	if results[1].State != models.StateDegraded || !results[1].IsRunning {
+		t.Errorf("Expected process2 to be degraded, got: %+v", results[1])
+	}
+}
@@ -0,0 +1,87 @@
+package test
+
+import (
+	"big-brother/internal/executor"
+	"big-brother/internal/scheduler"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestApp_StopEscalatesToKillCmd(t *testing.T) {
+	newApp, fake := newFakeApp(t)
+	fake.On("localhost", "echo 'checking process1 in service7'",
+		executor.FakeResponse{Output: "running"},
+		executor.FakeResponse{Output: "running"},
+		executor.FakeResponse{Output: "running"},
+		executor.FakeResponse{Output: ""})
+
+	results, err := newApp.StopService(context.Background(), "service7")
+	if err != nil {
+		t.Fatalf("StopService failed: %v", err)
+	}
+	if len(results) != 1 || results[0].Status != scheduler.StatusSucceeded {
+		t.Fatalf("Expected service7 to stop, got: %+v", results)
+	}
+	if expected := []string{"process1@localhost: ran kill_cmd"}; !reflect.DeepEqual(results[0].Notes, expected) {
+		t.Errorf("Expected notes %v, got %v", expected, results[0].Notes)
+	}
+
+	var actions []string
+	for _, call := range serviceCalls(fake, "service7") {
+		if call != "echo 'checking process1 in service7'" {
+			actions = append(actions, call)
+		}
+	}
+	expected := []string{"echo 'stopping process1 in service7'", "echo 'killing process1 in service7'"}
+	if !reflect.DeepEqual(actions, expected) {
+		t.Errorf("Expected actions %v, got %v", expected, actions)
+	}
+}
+
+func TestApp_StopEscalatesToSignalsForLocalPID(t *testing.T) {
+	// A process that ignores its stop_cmd and only goes away when signalled
+	cmd := exec.Command("sleep", "30")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Failed to start sleep: %v", err)
+	}
+	go cmd.Wait()
+	t.Cleanup(func() { cmd.Process.Kill() })
+
+	dir := t.TempDir()
+	pidFile := filepath.Join(dir, "sleep.pid")
+	if err := os.WriteFile(pidFile, []byte(strconv.Itoa(cmd.Process.Pid)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	config := fmt.Sprintf(`services:
+  - name: sleeper
+    processes:
+      - name: sleep
+        host_name: localhost
+        start_cmd: "true"
+        stop_cmd: "true"
+        stop_timeout: 1
+        health:
+          pidfile: %s
+`, pidFile)
+	newApp := newTestApp(t, writeConfig(t, "config.yaml", config), false, false, "")
+
+	results, err := newApp.StopService(context.Background(), "sleeper")
+	if err != nil {
+		t.Fatalf("StopService failed: %v", err)
+	}
+	if len(results) != 1 || results[0].Status != scheduler.StatusSucceeded {
+		t.Fatalf("Expected sleeper to stop, got: %+v", results)
+	}
+	if expected := []string{"sleep@localhost: sent SIGTERM"}; !reflect.DeepEqual(results[0].Notes, expected) {
+		t.Errorf("Expected notes %v, got %v", expected, results[0].Notes)
+	}
+	if processAlive(cmd.Process.Pid) {
+		t.Error("Process survived the escalated stop")
+	}
+}
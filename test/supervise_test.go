@@ -0,0 +1,117 @@
+package test
+
+import (
+	"big-brother/internal/app"
+	"big-brother/internal/scheduler"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newSupervisedApp writes a config where every process is up while a file
+// named after it exists in the returned directory, and logs its starts and
+// stops to the file "log" there.
+func newSupervisedApp(t *testing.T, services string) (*app.App, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	config := fmt.Sprintf(`ready_timeout: 2
+services:
+%s`, strings.ReplaceAll(services, "$DIR", dir))
+	return newTestApp(t, writeConfig(t, "config.yaml", config), false, false, ""), dir
+}
+
+// supervisedProcess renders a process for newSupervisedApp.
+func supervisedProcess(name, restartPolicy string) string {
+	return fmt.Sprintf(`      - name: %[1]s
+        host_name: localhost
+        shell: true
+        start_cmd: "touch $DIR/%[1]s && echo start %[1]s >> $DIR/log"
+        stop_cmd: "rm -f $DIR/%[1]s && echo stop %[1]s >> $DIR/log"
+        status_cmd: "test -f $DIR/%[1]s && echo up"
+        restart_policy: %[2]s
+`, name, restartPolicy)
+}
+
+func supervisedLog(t *testing.T, dir string) []string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, "log"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func setUp(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSupervisor_RestartsDependentsAfterRecovery(t *testing.T) {
+	newApp, dir := newSupervisedApp(t, `  - name: db
+    processes:
+`+supervisedProcess("db", "on-failure")+`  - name: api
+    depends_on: db
+    processes:
+`+supervisedProcess("api", "on-failure")+`  - name: batch
+    processes:
+`+supervisedProcess("batch", "never"))
+	setUp(t, dir, "db", "api", "batch")
+	supervisor := newApp.NewSupervisor()
+
+	if results := supervisor.Check(context.Background()); len(results) != 0 {
+		t.Fatalf("Expected nothing to restart, got: %+v", results)
+	}
+
+	for _, name := range []string{"db", "batch"} {
+		os.Remove(filepath.Join(dir, name))
+	}
+	results := supervisor.Check(context.Background())
+	if statuses := resultStatuses(results); !reflect.DeepEqual(statuses, map[string]scheduler.Status{
+		"db":  scheduler.StatusSucceeded,
+		"api": scheduler.StatusSucceeded,
+	}) {
+		t.Errorf("Unexpected results: %v", statuses)
+	}
+	expected := []string{"start db", "stop api", "start api"}
+	if log := supervisedLog(t, dir); !reflect.DeepEqual(log, expected) {
+		t.Errorf("Expected %v, got %v", expected, log)
+	}
+}
+
+func TestSupervisor_RestartPolicyModesAndLimit(t *testing.T) {
+	newApp, dir := newSupervisedApp(t, `  - name: service1
+    processes:
+`+supervisedProcess("always", "always")+supervisedProcess("failing", `
+          max_restarts: 2
+          window: 300`)+supervisedProcess("down", "on-failure"))
+	setUp(t, dir, "failing")
+	supervisor := newApp.NewSupervisor()
+
+	// always starts a process that was down from the beginning, on-failure
+	// only one that was seen running
+	supervisor.Check(context.Background())
+	if log := supervisedLog(t, dir); !reflect.DeepEqual(log, []string{"start always"}) {
+		t.Fatalf("Expected only always to start, got %v", log)
+	}
+
+	for i := 0; i < 3; i++ {
+		os.Remove(filepath.Join(dir, "failing"))
+		supervisor.Check(context.Background())
+	}
+	expected := []string{"start always", "start failing", "start failing"}
+	if log := supervisedLog(t, dir); !reflect.DeepEqual(log, expected) {
+		t.Errorf("Expected failing to be given up on after 2 restarts, got %v", log)
+	}
+}
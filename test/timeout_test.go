@@ -0,0 +1,137 @@
+package test
+
+import (
+	"big-brother/internal/executor"
+	"big-brother/internal/logger"
+	"big-brother/internal/models"
+	"big-brother/internal/utils"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestExecutor_ProcessTimeoutKillsProcessGroup(t *testing.T) {
+	newExecutor := executor.NewExecutor(logger.NewLogger(false))
+	pidFile := filepath.Join(t.TempDir(), "child.pid")
+
+	process := &models.Process{
+		Name:      "process1",
+		HostName:  "localhost",
+		Timeout:   1,
+		Shell:     true,
+		StatusCmd: "sleep 30 & echo $! > " + pidFile + "; wait",
+	}
+
+	started := time.Now()
+	_, err := newExecutor.RunProcessAction(context.Background(), process, executor.ActionStatus)
+	if !errors.Is(err, executor.ErrTimedOut) {
+		t.Fatalf("Expected a timeout error, got: %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("Timed out command took too long to return: %s", elapsed)
+	}
+
+	// The background child must have been killed along with the shell
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatalf("error reading child pid: %v", err)
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	time.Sleep(100 * time.Millisecond)
+	if processAlive(pid) {
+		syscall.Kill(pid, syscall.SIGKILL)
+		t.Error("Child process survived the timeout")
+	}
+}
+
+// processAlive reports whether pid exists and is not a zombie waiting to be
+// reaped.
+func processAlive(pid int) bool {
+	if err := syscall.Kill(pid, 0); err != nil {
+		return false
+	}
+	stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return true
+	}
+	fields := strings.Fields(string(stat))
+	return len(fields) < 3 || fields[2] != "Z"
+}
+
+func TestExecutor_CheckServiceReportsTimeout(t *testing.T) {
+	newExecutor := executor.NewExecutor(logger.NewLogger(false))
+	fake := executor.NewFakeRunner()
+	newExecutor.RegisterRunner(executor.RunnerLocal, fake)
+	fake.On("localhost", "status1", executor.FakeResponse{Output: "up", Delay: time.Minute})
+	fake.On("localhost", "status2", executor.FakeResponse{Output: "up"})
+
+	service := &models.Service{
+		Name: "service1",
+		Processes: []models.Process{
+			{Name: "process1", HostName: "localhost", StatusCmd: "status1", Timeout: 1},
+			{Name: "process2", HostName: "localhost", StatusCmd: "status2", Timeout: 1},
+		},
+	}
+
+	results := newExecutor.CheckService(context.Background(), service)
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results, got: %+v", results)
+	}
+	if results[0].State != models.StateTimedOut || results[0].IsRunning {
+		t.Errorf("Expected process1 to time out, got: %+v", results[0])
+	}
+	if results[1].State != models.StateRunning || !results[1].IsRunning {
+		t.Errorf("Expected process2 to be running, got: %+v", results[1])
+	}
+}
+
+func TestExecutor_ContextCancellation(t *testing.T) {
+	newExecutor := executor.NewExecutor(logger.NewLogger(false))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+
+	started := time.Now()
+	if _, err := newExecutor.ExecuteCommand(ctx, "sleep 30", "localhost"); err == nil {
+		t.Error("ExecuteCommand should have failed when the context was cancelled")
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("Cancelled command took too long to return: %s", elapsed)
+	}
+}
+
+func TestValidateConfig_TimeoutDefaults(t *testing.T) {
+	cfg := &models.Config{
+		Timeout: 30,
+		Services: []models.Service{
+			{Name: "service1", Timeout: 10, Processes: []models.Process{
+				{Name: "process1"},
+				{Name: "process2", Timeout: 5},
+			}},
+			{Name: "service2", Processes: []models.Process{{Name: "process1"}}},
+		},
+	}
+
+	if err := utils.ValidateConfigAndBuildDependencyTree(cfg); err != nil {
+		t.Fatalf("ValidateConfigAndBuildDependencyTree failed: %v", err)
+	}
+
+	if timeout := cfg.Services[0].Processes[0].Timeout; timeout != 10 {
+		t.Errorf("Expected service timeout 10, got %d", timeout)
+	}
+	if timeout := cfg.Services[0].Processes[1].Timeout; timeout != 5 {
+		t.Errorf("Expected process timeout 5, got %d", timeout)
+	}
+	if timeout := cfg.Services[1].Processes[0].Timeout; timeout != 30 {
+		t.Errorf("Expected global timeout 30, got %d", timeout)
+	}
+}
@@ -3,6 +3,7 @@ package test
 import (
 	"big-brother/internal/models"
 	"big-brother/internal/utils"
+	"reflect"
 	"testing"
 )
 
@@ -12,7 +13,7 @@ func TestValidateConfigAndBuildDependencyTree(t *testing.T) {
 	validConfig := &models.Config{
 		Services: []models.Service{
 			{Name: "service1"},
-			{Name: "service2", DependsOn: "service1"},
+			{Name: "service2", DependsOn: models.DependencyList{"service1"}},
 		},
 	}
 
@@ -24,8 +25,8 @@ func TestValidateConfigAndBuildDependencyTree(t *testing.T) {
 	// Invalid configuration with cyclic dependencies
 	invalidConfig := &models.Config{
 		Services: []models.Service{
-			{Name: "service1", DependsOn: "service2"},
-			{Name: "service2", DependsOn: "service1"},
+			{Name: "service1", DependsOn: models.DependencyList{"service2"}},
+			{Name: "service2", DependsOn: models.DependencyList{"service1"}},
 		},
 	}
 
@@ -50,7 +51,7 @@ func TestValidateConfigAndBuildDependencyTree(t *testing.T) {
 	// Test with a config that has dependencies
 	dependencyConfig := &models.Config{
 		Services: []models.Service{
-			{Name: "service1", DependsOn: "service2"},
+			{Name: "service1", DependsOn: models.DependencyList{"service2"}},
 			{Name: "service2"},
 		},
 	}
@@ -64,8 +65,9 @@ func TestValidateConfigAndBuildDependencyTree(t *testing.T) {
 	expectedTree := []*models.Service{
 		{Name: "service2", Dependents: []*models.Service{{Name: "service1"}}},
 	}
-	//TODO: Fix equals checking. Why is it failing?
-	if !(&dependencyConfig.DependencyTree == &expectedTree) {
+	tree := dependencyConfig.DependencyTree
+	if len(tree) != 1 || tree[0].Name != "service2" ||
+		!reflect.DeepEqual(getServiceNames(tree[0].Dependents), []string{"service1"}) {
 		t.Errorf("Incorrect dependency tree construction.\nExpected: %+v\nGot: %+v", expectedTree, dependencyConfig.DependencyTree)
 	}
 
@@ -164,3 +166,154 @@ func TestFindProcessByName(t *testing.T) {
 		t.Error("FindProcessByName should have failed for nonexistent process")
 	}
 }
+
+func TestValidateProcessCommands(t *testing.T) {
+	bothSet := &models.Config{
+		Services: []models.Service{
+			{Name: "service1", Processes: []models.Process{
+				{Name: "process1", StartCmd: "run.sh", Argv: models.ProcessArgv{Start: []string{"run.sh"}}},
+			}},
+		},
+	}
+	if err := utils.ValidateConfigAndBuildDependencyTree(bothSet); err == nil {
+		t.Error("ValidateConfigAndBuildDependencyTree should have failed when start_cmd and argv.start are both set")
+	}
+
+	needsShell := &models.Config{
+		Services: []models.Service{
+			{Name: "service1", Processes: []models.Process{
+				{Name: "process1", StatusCmd: "pgrep java | wc -l"},
+			}},
+		},
+	}
+	if err := utils.ValidateConfigAndBuildDependencyTree(needsShell); err == nil {
+		t.Error("ValidateConfigAndBuildDependencyTree should have failed for shell syntax without shell: true")
+	}
+
+	needsShell.Services[0].Processes[0].Shell = true
+	if err := utils.ValidateConfigAndBuildDependencyTree(needsShell); err != nil {
+		t.Errorf("ValidateConfigAndBuildDependencyTree failed with shell: true: %v", err)
+	}
+}
+
+func TestMultipleDependencies(t *testing.T) {
+	cfg := &models.Config{
+		Services: []models.Service{
+			{Name: "app", DependsOn: models.DependencyList{"db", "cache"}},
+			{Name: "db"},
+			{Name: "web", DependsOn: models.DependencyList{"app"}},
+			{Name: "cache"},
+			{Name: "worker", DependsOn: models.DependencyList{"db"}},
+		},
+	}
+
+	if err := utils.ValidateConfigAndBuildDependencyTree(cfg); err != nil {
+		t.Fatalf("ValidateConfigAndBuildDependencyTree failed: %v", err)
+	}
+
+	if names := getServiceNames(cfg.DependencyTree); !reflect.DeepEqual(names, []string{"db", "cache"}) {
+		t.Errorf("Expected roots [db cache], got %v", names)
+	}
+	if names := getServiceNames(cfg.StartOrder); !reflect.DeepEqual(names, []string{"db", "cache", "app", "web", "worker"}) {
+		t.Errorf("Unexpected start order: %v", names)
+	}
+
+	app, _ := utils.FindServiceByName(cfg, "app")
+	if names := getServiceNames(app.Dependencies); !reflect.DeepEqual(names, []string{"db", "cache"}) {
+		t.Errorf("Expected app to depend on [db cache], got %v", names)
+	}
+	db, _ := utils.FindServiceByName(cfg, "db")
+	if names := getServiceNames(db.Dependents); !reflect.DeepEqual(names, []string{"app", "worker"}) {
+		t.Errorf("Expected [app worker] to depend on db, got %v", names)
+	}
+
+	startWaves := utils.Waves(cfg.StartOrder, utils.Dependencies)
+	expectedWaves := [][]string{{"db", "cache"}, {"app", "worker"}, {"web"}}
+	if len(startWaves) != len(expectedWaves) {
+		t.Fatalf("Expected %d start waves, got %d", len(expectedWaves), len(startWaves))
+	}
+	for i, wave := range startWaves {
+		if names := getServiceNames(wave); !reflect.DeepEqual(names, expectedWaves[i]) {
+			t.Errorf("Start wave %d: expected %v, got %v", i, expectedWaves[i], names)
+		}
+	}
+
+	// Stopping goes the other way: app waits for web, db for app and worker
+	stopWaves := utils.Waves(cfg.StartOrder, utils.Dependents)
+	expectedWaves = [][]string{{"web", "worker"}, {"app"}, {"db", "cache"}}
+	if len(stopWaves) != len(expectedWaves) {
+		t.Fatalf("Expected %d stop waves, got %d", len(expectedWaves), len(stopWaves))
+	}
+	for i, wave := range stopWaves {
+		if names := getServiceNames(wave); !reflect.DeepEqual(names, expectedWaves[i]) {
+			t.Errorf("Stop wave %d: expected %v, got %v", i, expectedWaves[i], names)
+		}
+	}
+}
+
+func TestDependencyValidation(t *testing.T) {
+	unknown := &models.Config{
+		Services: []models.Service{{Name: "app", DependsOn: models.DependencyList{"db", "missing"}}, {Name: "db"}},
+	}
+	if err := utils.ValidateConfigAndBuildDependencyTree(unknown); err == nil {
+		t.Error("ValidateConfigAndBuildDependencyTree should have failed for an unknown dependency")
+	}
+
+	cyclic := &models.Config{
+		Services: []models.Service{
+			{Name: "a", DependsOn: models.DependencyList{"c"}},
+			{Name: "b", DependsOn: models.DependencyList{"a"}},
+			{Name: "c", DependsOn: models.DependencyList{"x", "b"}},
+			{Name: "x"},
+		},
+	}
+	if err := utils.ValidateConfigAndBuildDependencyTree(cyclic); err == nil {
+		t.Error("ValidateConfigAndBuildDependencyTree should have failed for a cycle through a second dependency")
+	}
+}
+
+func TestWithDependents(t *testing.T) {
+	services := buildServices(t, [][2]string{{"app", "db"}, {"web", "app"}, {"worker", "cache"}, {"report", "web"}, {"report", "worker"}}, "db", "cache", "app", "web", "worker", "report")
+
+	var app *models.Service
+	for _, service := range services {
+		if service.Name == "app" {
+			app = service
+		}
+	}
+
+	got := getServiceNames(utils.WithDependents(services, app))
+	expected := []string{"app", "web", "report"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestParseBatchSize(t *testing.T) {
+	tests := []struct {
+		spec     string
+		total    int
+		expected int
+		wantErr  bool
+	}{
+		{"1", 4, 1, false},
+		{"3", 2, 2, false},
+		{"50%", 4, 2, false},
+		{"25%", 3, 1, false},
+		{"100%", 3, 3, false},
+		{"1%", 3, 1, false},
+		{"0", 3, 0, true},
+		{"150%", 3, 0, true},
+		{"two", 3, 0, true},
+	}
+	for _, tt := range tests {
+		got, err := utils.ParseBatchSize(tt.spec, tt.total)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseBatchSize(%q, %d) error = %v, wantErr %v", tt.spec, tt.total, err, tt.wantErr)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("ParseBatchSize(%q, %d) = %d, expected %d", tt.spec, tt.total, got, tt.expected)
+		}
+	}
+}